@@ -0,0 +1,245 @@
+package rrule
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// binaryVersion is the version of the format written by MarshalBinary.
+// Version 2 adds RScale; UnmarshalBinary also reads version 1.
+const binaryVersion = 2
+
+// minBinaryTime and maxBinaryTime bound the times representable as
+// Unix nanoseconds.
+var (
+	minBinaryTime = time.Unix(0, -1<<63)
+	maxBinaryTime = time.Unix(0, 1<<63-1)
+)
+
+const (
+	binaryRFC = 1 << iota
+	binaryDtstart
+	binaryUntil
+	binaryCountSet
+	binaryGenerateInclusive
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler, encoding the options
+// the rule was constructed with in a compact, versioned binary format.
+// Times are stored as Unix nanoseconds, so DTSTART and UNTIL must be
+// between the years 1678 and 2262.
+func (r *RRule) MarshalBinary() ([]byte, error) {
+	option := r.OrigOptions
+	for _, t := range []time.Time{option.Dtstart, option.Until} {
+		if !t.IsZero() && (t.Before(minBinaryTime) || t.After(maxBinaryTime)) {
+			return nil, fmt.Errorf("time out of range: %v", t)
+		}
+	}
+	var b bytes.Buffer
+	var flags byte
+	if option.RFC {
+		flags |= binaryRFC
+	}
+	if !option.Dtstart.IsZero() {
+		flags |= binaryDtstart
+	}
+	if !option.Until.IsZero() {
+		flags |= binaryUntil
+	}
+	if option.CountSet {
+		flags |= binaryCountSet
+	}
+	if option.GenerateInclusive {
+		flags |= binaryGenerateInclusive
+	}
+	b.Write([]byte{binaryVersion, byte(option.Freq), flags})
+	if !option.Dtstart.IsZero() {
+		writeBinaryTime(&b, option.Dtstart)
+	}
+	if !option.Until.IsZero() {
+		writeBinaryTime(&b, option.Until)
+	}
+	binary.Write(&b, binary.BigEndian, uint32(option.Interval))
+	binary.Write(&b, binary.BigEndian, uint32(option.Count))
+	b.WriteByte(byte(option.Wkst.weekday))
+	for _, ints := range [][]int{option.Bysetpos, option.Bymonth, option.Bymonthday, option.Byyearday,
+		option.Byweekno, option.Byhour, option.Byminute, option.Bysecond, option.Byeaster} {
+		binary.Write(&b, binary.BigEndian, uint16(len(ints)))
+		for _, v := range ints {
+			binary.Write(&b, binary.BigEndian, int16(v))
+		}
+	}
+	binary.Write(&b, binary.BigEndian, uint16(len(option.Byweekday)))
+	for _, wday := range option.Byweekday {
+		b.Write([]byte{byte(wday.weekday), byte(int8(wday.n))})
+	}
+	binary.Write(&b, binary.BigEndian, uint16(len(option.WeekdayNames)))
+	for _, name := range option.WeekdayNames {
+		writeBinaryString(&b, name)
+	}
+	writeBinaryString(&b, option.RScale)
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, decoding data
+// written by MarshalBinary and constructing the rule with NewRRule.
+func (r *RRule) UnmarshalBinary(data []byte) error {
+	d := bytes.NewReader(data)
+	var header [3]byte
+	if _, err := io.ReadFull(d, header[:]); err != nil {
+		return fmt.Errorf("bad binary rule: %v", err)
+	}
+	if header[0] != 1 && header[0] != binaryVersion {
+		return fmt.Errorf("unsupported binary rule version: %d", header[0])
+	}
+	option := ROption{Freq: Frequency(header[1]), RFC: header[2]&binaryRFC != 0,
+		CountSet: header[2]&binaryCountSet != 0, GenerateInclusive: header[2]&binaryGenerateInclusive != 0}
+	if option.Freq > SECONDLY {
+		return fmt.Errorf("bad binary rule: undefined frequency %d", header[1])
+	}
+	var err error
+	if header[2]&binaryDtstart != 0 {
+		option.Dtstart, err = readBinaryTime(d)
+	}
+	if err == nil && header[2]&binaryUntil != 0 {
+		option.Until, err = readBinaryTime(d)
+	}
+	var interval, count uint32
+	var wkst byte
+	for _, v := range []interface{}{&interval, &count, &wkst} {
+		if err == nil {
+			err = binary.Read(d, binary.BigEndian, v)
+		}
+	}
+	option.Interval, option.Count = int(interval), int(count)
+	if wkst > 6 {
+		return fmt.Errorf("bad binary rule: undefined weekday %d", wkst)
+	}
+	option.Wkst = weekdays[wkst]
+	for _, ints := range []*[]int{&option.Bysetpos, &option.Bymonth, &option.Bymonthday, &option.Byyearday,
+		&option.Byweekno, &option.Byhour, &option.Byminute, &option.Bysecond, &option.Byeaster} {
+		var values []int16
+		if values, err = readBinarySlice(d, err); err == nil && len(values) != 0 {
+			*ints = make([]int, len(values))
+			for i, v := range values {
+				(*ints)[i] = int(v)
+			}
+		}
+	}
+	var wdays uint16
+	if err == nil {
+		err = binary.Read(d, binary.BigEndian, &wdays)
+	}
+	for i := 0; err == nil && i < int(wdays); i++ {
+		var wday [2]byte
+		if _, err = io.ReadFull(d, wday[:]); err == nil {
+			if wday[0] > 6 {
+				return fmt.Errorf("bad binary rule: undefined weekday %d", wday[0])
+			}
+			option.Byweekday = append(option.Byweekday, weekdays[wday[0]].Nth(int(int8(wday[1]))))
+		}
+	}
+	var names uint16
+	if err == nil {
+		err = binary.Read(d, binary.BigEndian, &names)
+	}
+	for i := 0; err == nil && i < int(names); i++ {
+		var name string
+		name, err = readBinaryString(d)
+		option.WeekdayNames = append(option.WeekdayNames, name)
+	}
+	if err == nil && header[0] >= 2 {
+		option.RScale, err = readBinaryString(d)
+	}
+	if err != nil {
+		return fmt.Errorf("bad binary rule: %v", err)
+	}
+	if d.Len() != 0 {
+		return errors.New("bad binary rule: trailing data")
+	}
+	rule, err := NewRRule(option)
+	if err != nil {
+		return err
+	}
+	*r = *rule
+	return nil
+}
+
+// writeBinaryTime writes t as Unix nanoseconds followed by its location
+// name and offset.
+func writeBinaryTime(b *bytes.Buffer, t time.Time) {
+	binary.Write(b, binary.BigEndian, t.UnixNano())
+	_, offset := t.Zone()
+	binary.Write(b, binary.BigEndian, int32(offset))
+	writeBinaryString(b, t.Location().String())
+}
+
+// readBinaryTime reads a time written by writeBinaryTime. The location is
+// looked up by name, and replaced by a fixed zone if it can not be loaded.
+func readBinaryTime(d *bytes.Reader) (time.Time, error) {
+	var nsec int64
+	var offset int32
+	if err := binary.Read(d, binary.BigEndian, &nsec); err != nil {
+		return time.Time{}, err
+	}
+	if err := binary.Read(d, binary.BigEndian, &offset); err != nil {
+		return time.Time{}, err
+	}
+	name, err := readBinaryString(d)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var loc *time.Location
+	switch name {
+	case "UTC":
+		loc = time.UTC
+	case "Local":
+		loc = time.Local
+	default:
+		if loc, err = time.LoadLocation(name); err != nil {
+			loc = time.FixedZone(name, int(offset))
+		}
+	}
+	return time.Unix(0, nsec).In(loc), nil
+}
+
+func writeBinaryString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
+
+func readBinaryString(d *bytes.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(d, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	s := make([]byte, n)
+	if _, err := io.ReadFull(d, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// readBinarySlice reads a uint16 length followed by as many int16 values,
+// unless err is already set.
+func readBinarySlice(d *bytes.Reader, err error) ([]int16, error) {
+	if err != nil {
+		return nil, err
+	}
+	var n uint16
+	if err := binary.Read(d, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	if int(n)*2 > d.Len() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	values := make([]int16, n)
+	if err := binary.Read(d, binary.BigEndian, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,75 @@
+package rrule
+
+import (
+	"encoding"
+	"testing"
+	"time"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = &RRule{}
+	_ encoding.BinaryUnmarshaler = &RRule{}
+)
+
+func TestRRuleBinary(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	options := []ROption{
+		{Freq: WEEKLY, Count: 10, Byweekday: []Weekday{MO, FR}, Dtstart: time.Date(2018, 1, 1, 9, 0, 0, 0, ny)},
+		{Freq: MONTHLY, Interval: 2, Wkst: SU, Byweekday: []Weekday{SU.Nth(-1), MO.Nth(2)}, Bysetpos: []int{-1},
+			Until: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Dtstart: time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)},
+		{Freq: YEARLY, Byeaster: []int{-2, 0}, Byhour: []int{9, 17}, Dtstart: time.Date(2018, 1, 1, 9, 0, 0, 0, time.FixedZone("X", 3600))},
+		{Freq: DAILY, RFC: true, WeekdayNames: []string{"LU", "MA", "ME", "JE", "VE", "SA", "DI"},
+			Dtstart: time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)},
+		{Freq: HOURLY, CountSet: true, GenerateInclusive: true, Dtstart: time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)},
+		{Freq: MONTHLY, RScale: "GREGORIAN", Dtstart: time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, option := range options {
+		r, _ := NewRRule(option)
+		data, err := r.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) >= 100 {
+			t.Errorf("%v: get %d bytes, want less than 100", r, len(data))
+		}
+		var decoded RRule
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%v: %v", r, err)
+		}
+		if decoded.String() != r.String() || decoded.OrigOptions.RScale != option.RScale {
+			t.Errorf("get %v, want %v", &decoded, r)
+		}
+		want, value := r.Between(option.Dtstart, option.Dtstart.AddDate(2, 0, 0), true),
+			decoded.Between(option.Dtstart, option.Dtstart.AddDate(2, 0, 0), true)
+		if len(value) != len(want) {
+			t.Fatalf("%v: get %v, want %v", r, value, want)
+		}
+		for i := range value {
+			if !value[i].Equal(want[i]) || value[i].Location().String() != want[i].Location().String() {
+				t.Errorf("%v: get %v, want %v", r, value[i], want[i])
+			}
+		}
+
+		for i := 0; i < len(data); i++ {
+			if err := decoded.UnmarshalBinary(data[:i]); err == nil {
+				t.Errorf("%v: get nil error for %d bytes, want error", r, i)
+			}
+		}
+	}
+
+	var decoded RRule
+	if err := decoded.UnmarshalBinary([]byte{3, 0, 0}); err == nil {
+		t.Error("get nil error for unknown version, want error")
+	}
+	// Version 1 has no RScale.
+	r, _ := NewRRule(options[0])
+	data, _ := r.MarshalBinary()
+	data = append([]byte{1}, data[1:len(data)-2]...)
+	if err := decoded.UnmarshalBinary(data); err != nil || decoded.String() != r.String() {
+		t.Errorf("get %v, %v, want %v", &decoded, err, r)
+	}
+	r, _ = NewRRule(ROption{Freq: DAILY, Dtstart: time.Date(2300, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if _, err := r.MarshalBinary(); err == nil {
+		t.Error("get nil error for out of range DTSTART, want error")
+	}
+}
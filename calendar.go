@@ -0,0 +1,244 @@
+package rrule
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// calendarProdID is the PRODID of calendars written by ToTextCalendar.
+const calendarProdID = "-//teambition//rrule-go//EN"
+
+// ToTextCalendar returns a minimal iCalendar document with a single
+// recurring VEVENT for the rule. Lines end with CRLF and are folded at
+// 75 octets as required by RFC 5545. DTSTART is written with its TZID and
+// a VTIMEZONE describing its time zone if it is in a named time zone, and
+// in UTC otherwise. The UID is derived from the rule, and DTSTAMP is
+// DTSTART, so the output only depends on the rule. The rule is written by
+// ToRFC5545, and an error is returned if it uses extensions.
+func (r *RRule) ToTextCalendar() (string, error) {
+	rule, err := r.ToRFC5545()
+	if err != nil {
+		return "", err
+	}
+	dtstart := r.DateStart
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:" + calendarProdID,
+	}
+	if hasNamedZone(dtstart) {
+		vtimezone, err := vtimezoneLines(dtstart.Location(), dtstart.Year())
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, vtimezone...)
+	} else {
+		dtstart = dtstart.UTC()
+	}
+	lines = append(lines,
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:%x@rrule-go", sha1.Sum([]byte(timeToDtStartStr(dtstart)+rule))),
+		"DTSTAMP:"+timeToStr(dtstart),
+		"DTSTART"+timeToDtStartStr(dtstart),
+		"RRULE:"+rule,
+		"END:VEVENT",
+		"END:VCALENDAR",
+	)
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(foldLine(line) + "\r\n")
+	}
+	return b.String(), nil
+}
+
+// ExportICS writes an iCalendar document with a single recurring VEVENT
+// for the set to w, with eventSummary as SUMMARY. The UID is derived from
+// the set and DTSTAMP is the current time. DTSTART is the one inferred by
+// InferDTStart, or the first RDATE; an error is returned if the set has
+// none, or if its rules do not all start at DTSTART, as a VEVENT has a
+// single DTSTART. Each TZID is defined by a VTIMEZONE. A truncated set is
+// only exported if the truncation can be written as the COUNT of its single
+// rule. An error is returned for sets with EXRULEs, which RFC 5545
+// deprecates, rules using extensions, see RRule.ToRFC5545, skipped
+// occurrences, exclusion ranges or merged sets with exclusions, which
+// iCalendar can not express. Lines end with CRLF and are folded at 75
+// octets.
+func (set *Set) ExportICS(w io.Writer, eventSummary string) error {
+	dtstart := set.InferDTStart()
+	if dtstart.IsZero() && len(set.rdate) != 0 {
+		dtstart = uniqueTimes(set.rdate)[0]
+	}
+	if dtstart.IsZero() {
+		return errors.New("set has no DTSTART")
+	}
+	for _, r := range set.rrule {
+		if !r.DateStart.Equal(dtstart) {
+			return fmt.Errorf("rule %v does not start at DTSTART %v", r, dtstart)
+		}
+	}
+	switch {
+	case len(set.exrule) != 0:
+		return errors.New("EXRULE is deprecated by RFC 5545")
+	case set.skip != 0:
+		return errors.New("can not export a set with skipped occurrences")
+	case len(set.exrange) != 0:
+		return errors.New("can not export a set with exclusion ranges")
+	case len(set.merged) != 0:
+		return errors.New("can not export a merged set with exclusions")
+	}
+	count, hasCount := set.countRule()
+	if set.truncated && !hasCount {
+		return errors.New("can not export a truncated set with more than a rule")
+	}
+	if !hasNamedZone(dtstart) {
+		dtstart = dtstart.UTC()
+	}
+	var props []string
+	for _, r := range set.rrule {
+		option := r.OrigOptions
+		if hasCount {
+			option = count
+		}
+		rule, err := optionToRFC5545(option)
+		if err != nil {
+			return err
+		}
+		props = append(props, "RRULE:"+rule)
+	}
+	props = append(props, dateLines("RDATE", set.rdate)...)
+	props = append(props, dateLines("EXDATE", set.exdate)...)
+	uid := sha1.Sum([]byte(timeToDtStartStr(dtstart) + strings.Join(props, "\n")))
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:" + calendarProdID,
+	}
+	// Each named time zone is described as of the year of its first time.
+	zones := map[string]bool{}
+	for _, t := range append(append([]time.Time{dtstart}, set.rdate...), set.exdate...) {
+		if !hasNamedZone(t) || zones[t.Location().String()] {
+			continue
+		}
+		zones[t.Location().String()] = true
+		vtimezone, err := vtimezoneLines(t.Location(), t.Year())
+		if err != nil {
+			return err
+		}
+		lines = append(lines, vtimezone...)
+	}
+	lines = append(lines,
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:%x@rrule-go", uid),
+		"DTSTAMP:"+timeToStr(time.Now()),
+		"DTSTART"+timeToDtStartStr(dtstart),
+		"SUMMARY:"+escapeText(eventSummary),
+	)
+	lines = append(lines, props...)
+	lines = append(lines, "END:VEVENT", "END:VCALENDAR")
+	for _, line := range lines {
+		if _, err := io.WriteString(w, foldLine(line)+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapeText escapes s as a TEXT value of RFC 5545 section 3.3.11.
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// foldLine splits line into lines of at most 75 octets, joined by CRLF
+// followed by a space, without splitting UTF-8 sequences.
+func foldLine(line string) string {
+	var b strings.Builder
+	limit := 75
+	for len(line) > limit {
+		i := limit
+		for i > 0 && line[i]&0xC0 == 0x80 {
+			i--
+		}
+		b.WriteString(line[:i] + "\r\n ")
+		line = line[i:]
+		// The leading space counts towards the limit.
+		limit = 74
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// RRuleFromTextCalendar returns the rule made of the DTSTART and the first
+// RRULE of the first VEVENT of an iCalendar document. Time zones defined
+// by VTIMEZONE components of the document are taken into account.
+func RRuleFromTextCalendar(s string) (*RRule, error) {
+	zones, lines, err := extractVTimezones(unfoldLines(s))
+	if err != nil {
+		return nil, err
+	}
+	var dtstart, rule string
+	inEvent, found := false, false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, err := processRRuleName(line)
+		if err != nil {
+			return nil, err
+		}
+		value := line[len(name):]
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, ":VEVENT"):
+			// Only the first VEVENT is read.
+			inEvent = !found
+			found = true
+		case name == "END" && strings.EqualFold(value, ":VEVENT"):
+			inEvent = false
+		case inEvent && name == "DTSTART":
+			dtstart = value[1:]
+		case inEvent && name == "RRULE" && rule == "":
+			rule = value[1:]
+		}
+	}
+	if !found {
+		return nil, errors.New("no VEVENT found")
+	}
+	if rule == "" {
+		return nil, errors.New("no RRULE found in VEVENT")
+	}
+	loc := time.UTC
+	var dt time.Time
+	if dtstart != "" {
+		if dt, err = strToDtStartInZones(dtstart, time.UTC, zones); err != nil {
+			return nil, fmt.Errorf("strToDtStart failed: %v", err)
+		}
+		loc = dt.Location()
+	}
+	option, err := StrToROptionInLocation(rule, loc)
+	if err != nil {
+		return nil, err
+	}
+	if dtstart != "" {
+		option.Dtstart = dt
+		option.RFC = false
+	}
+	return NewRRule(*option)
+}
+
+// unfoldLines splits s into content lines, joining lines folded as
+// described by RFC 5545 section 3.1.
+func unfoldLines(s string) []string {
+	var result []string
+	for _, line := range strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n") {
+		if len(result) != 0 && line != "" && (line[0] == ' ' || line[0] == '\t') {
+			result[len(result)-1] += line[1:]
+			continue
+		}
+		result = append(result, line)
+	}
+	return result
+}
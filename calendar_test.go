@@ -0,0 +1,224 @@
+package rrule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToTextCalendar(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Count: 3, Byweekday: []Weekday{MO, WE},
+		Dtstart: time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)})
+	value, err := r.ToTextCalendar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//teambition//rrule-go//EN\r\nBEGIN:VEVENT\r\n",
+		"\r\nDTSTAMP:20180101T090000Z\r\nDTSTART:20180101T090000Z\r\nRRULE:FREQ=WEEKLY;COUNT=3;BYDAY=MO,WE\r\n",
+		"\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(value, line) {
+			t.Errorf("get %q, want it to contain %q", value, line)
+		}
+	}
+	if again, _ := r.ToTextCalendar(); !strings.Contains(value, "\r\nUID:") || value != again {
+		t.Errorf("get %q, want a stable UID", value)
+	}
+	if strings.Contains(value, "VTIMEZONE") {
+		t.Errorf("get %q, want no VTIMEZONE", value)
+	}
+
+	decoded, err := RRuleFromTextCalendar(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := r.All(); !timesEqual(decoded.All(), want) {
+		t.Errorf("get %v, want %v", decoded.All(), want)
+	}
+
+	ny, _ := time.LoadLocation("America/New_York")
+	r, _ = NewRRule(ROption{Freq: DAILY, Count: 2, Dtstart: time.Date(2018, 3, 10, 9, 0, 0, 0, ny)})
+	if value, err = r.ToTextCalendar(); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"\r\nDTSTART;TZID=America/New_York:20180310T090000\r\n",
+		"\r\nBEGIN:VTIMEZONE\r\nTZID:America/New_York\r\n",
+		"\r\nBEGIN:DAYLIGHT\r\nDTSTART:20180311T020000\r\nTZOFFSETFROM:-0500\r\nTZOFFSETTO:-0400\r\nRRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=+2SU\r\n",
+		"\r\nBEGIN:STANDARD\r\nDTSTART:20181104T020000\r\nTZOFFSETFROM:-0400\r\nTZOFFSETTO:-0500\r\nRRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=+1SU\r\n",
+	} {
+		if !strings.Contains(value, line) {
+			t.Errorf("get %q, want it to contain %q", value, line)
+		}
+	}
+	decoded, err = RRuleFromTextCalendar(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range decoded.All() {
+		if want := r.All()[i]; !v.Equal(want) || v.Location().String() != "America/New_York" {
+			t.Errorf("get %v, want %v", v, want)
+		}
+	}
+
+	r, _ = NewRRule(ROption{Freq: YEARLY, Byeaster: []int{0}, Dtstart: time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)})
+	if _, err := r.ToTextCalendar(); err == nil {
+		t.Error("get nil, want error for BYEASTER")
+	}
+}
+
+func TestRRuleFromTextCalendar(t *testing.T) {
+	s := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"PRODID:-//Example//EN\r\n" +
+		"BEGIN:VTIMEZONE\r\n" +
+		"TZID:Fictional/Island\r\n" +
+		"BEGIN:STANDARD\r\n" +
+		"DTSTART:19700101T000000\r\n" +
+		"TZOFFSETFROM:+0300\r\n" +
+		"TZOFFSETTO:+0300\r\n" +
+		"END:STANDARD\r\n" +
+		"END:VTIMEZONE\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:1@example.com\r\n" +
+		"DTSTART;TZID=Fictional/Island:20180101T090000\r\n" +
+		"RRULE:FREQ=DAILY;\r\n" +
+		" COUNT=2\r\n" +
+		"RRULE:FREQ=YEARLY\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"RRULE:FREQ=MONTHLY\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	r, err := RRuleFromTextCalendar(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := r.All()
+	want := []time.Time{time.Date(2018, 1, 1, 6, 0, 0, 0, time.UTC), time.Date(2018, 1, 2, 6, 0, 0, 0, time.UTC)}
+	if len(value) != len(want) || !value[0].Equal(want[0]) || !value[1].Equal(want[1]) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	for _, s := range []string{
+		"BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20180101T090000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nRRULE:FREQ=HELLO\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if _, err := RRuleFromTextCalendar(s); err == nil {
+			t.Errorf("RRuleFromTextCalendar(%q) = nil error, want error", s)
+		}
+	}
+}
+
+func TestExportICS(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	set := Set{}
+	set.DTStart(dtstart)
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO, TU, WE, TH, FR}, Byhour: []int{9, 12, 15, 18},
+		Until: time.Date(2018, 12, 31, 0, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	set.RDate(time.Date(2018, 1, 6, 9, 0, 0, 0, time.UTC))
+	set.ExDate(time.Date(2018, 1, 2, 9, 0, 0, 0, time.UTC))
+	var b strings.Builder
+	if err := set.ExportICS(&b, "Stand-up; daily, with coffee"); err != nil {
+		t.Fatal(err)
+	}
+	value := b.String()
+	for _, line := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//teambition//rrule-go//EN\r\nBEGIN:VEVENT\r\nUID:",
+		"\r\nDTSTART:20180101T090000Z\r\n" + `SUMMARY:Stand-up\; daily\, with coffee` + "\r\n",
+		"\r\nRRULE:FREQ=WEEKLY;UNTIL=20181231T000000Z;BYHOUR=9,12,15,18;BYDAY=FR,MO,TH,T\r\n U,WE\r\n",
+		"\r\nRDATE:20180106T090000Z\r\nEXDATE:20180102T090000Z\r\n",
+		"\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(value, line) {
+			t.Errorf("get %q, want it to contain %q", value, line)
+		}
+	}
+	for _, line := range strings.Split(value, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line %q is longer than 75 octets", line)
+		}
+	}
+
+	if err := (&Set{}).ExportICS(&b, ""); err == nil {
+		t.Error("get nil error for empty set, want error")
+	}
+}
+
+func TestExportICSTimezones(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	london, _ := time.LoadLocation("Europe/London")
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, ny)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 10})
+	set, _ := NewRRuleSet(dtstart, r)
+	set.RDate(time.Date(2018, 1, 20, 9, 0, 0, 0, london))
+	set.ExDate(time.Date(2018, 1, 2, 9, 0, 0, 0, ny))
+	var b strings.Builder
+	if err := set.ExportICS(&b, ""); err != nil {
+		t.Fatal(err)
+	}
+	value := b.String()
+	for _, name := range []string{"America/New_York", "Europe/London"} {
+		if !strings.Contains(value, ";TZID="+name+":") {
+			t.Errorf("get %q, want a time with TZID %s", value, name)
+		}
+		if strings.Count(value, "\r\nTZID:"+name+"\r\n") != 1 {
+			t.Errorf("get %q, want a VTIMEZONE for %s", value, name)
+		}
+	}
+	zones, _, err := extractVTimezones(unfoldLines(value))
+	if err != nil || len(zones) != 2 {
+		t.Errorf("get %v, %v, want 2 time zones", zones, err)
+	}
+}
+
+func TestExportICSUnsupported(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	daily, _ := NewRRule(ROption{Freq: DAILY, Dtstart: dtstart})
+	weekly, _ := NewRRule(ROption{Freq: WEEKLY, Dtstart: dtstart.Add(time.Hour)})
+	set := &Set{}
+	set.RRule(daily)
+
+	var b strings.Builder
+	if err := set.Truncate(3).ExportICS(&b, ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\r\nRRULE:FREQ=DAILY;COUNT=3\r\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("get %q, want it to contain %q", b.String(), want)
+	}
+
+	withRange := set.clone()
+	withRange.AddExcludeRange(dtstart, dtstart.AddDate(0, 0, 1))
+	twoRules := set.clone()
+	twoRules.RRule(weekly)
+	withExDate := set.clone()
+	withExDate.ExDate(dtstart)
+	withExRule := set.clone()
+	withExRule.ExRule(weekly)
+	easter, _ := NewRRule(ROption{Freq: YEARLY, Byeaster: []int{0}})
+	withEaster, _ := NewRRuleSet(dtstart, easter)
+	for _, s := range []*Set{set.Skip(1), set.Truncate(3).Skip(1), withRange, twoRules,
+		twoRules.Truncate(3), withExDate.Truncate(3), withExDate.Merge(set), withExRule, withEaster} {
+		if err := s.ExportICS(&b, ""); err == nil {
+			t.Errorf("get nil error for %v, want error", s)
+		}
+	}
+}
+
+func TestFoldLine(t *testing.T) {
+	line := "SUMMARY:" + strings.Repeat("é", 100)
+	folded := foldLine(line)
+	parts := strings.Split(folded, "\r\n ")
+	if len(parts) != 3 || strings.Join(parts, "") != line {
+		t.Fatalf("get %q", folded)
+	}
+	for i, part := range parts {
+		// Continuation lines start with a space.
+		if i > 0 && len(part)+1 > 75 || len(part) > 75 {
+			t.Errorf("part %d %q is too long", i, part)
+		}
+	}
+}
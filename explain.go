@@ -0,0 +1,118 @@
+package rrule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Explain returns a human-readable description of how the RRule evaluates t:
+// which of the BYXXX filters it passes or fails and whether it ends up being
+// an occurrence. The output is meant for debugging and is not machine-parseable.
+func (r *RRule) Explain(t time.Time) string {
+	var lines []string
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+	verdict := func(ok bool) string {
+		if ok {
+			return "passed"
+		}
+		return "FAILED"
+	}
+
+	t = t.In(r.DateStart.Location())
+	add("Evaluating %v against rule %v.", t, r)
+	add("Frequency is %v with interval %d; DTSTART is %v.", r.Freq, r.Interval, r.DateStart)
+
+	year, month, _ := t.Date()
+	if year < 1 || year > MAXYEAR {
+		add("Year %d is outside the supported range 1-%d.", year, MAXYEAR)
+		add("Result: %v is NOT an occurrence.", t)
+		return strings.Join(lines, "\n")
+	}
+	if t.Before(r.DateStart) {
+		add("It is before DTSTART, so it cannot be an occurrence.")
+	}
+	if !r.UntilTime.IsZero() && t.After(r.UntilTime) {
+		add("It is after UNTIL %v, so it cannot be an occurrence.", r.UntilTime)
+	}
+	if t.Nanosecond() != 0 {
+		add("It has a sub-second component, but occurrences are always whole seconds.")
+	}
+
+	passed := true
+	check := func(name string, ok bool, format string, a ...interface{}) {
+		add("%s %s: %s.", name, verdict(ok), fmt.Sprintf(format, a...))
+		passed = passed && ok
+	}
+
+	hour, minute, second := t.Clock()
+	if len(r.Byhour) != 0 {
+		check("BYHOUR", contains(r.Byhour, hour), "hour %d, allowed %v", hour, r.Byhour)
+	}
+	if len(r.Byminute) != 0 {
+		check("BYMINUTE", contains(r.Byminute, minute), "minute %d, allowed %v", minute, r.Byminute)
+	}
+	if len(r.Bysecond) != 0 {
+		check("BYSECOND", contains(r.Bysecond, second), "second %d, allowed %v", second, r.Bysecond)
+	}
+
+	yearlen := 365 + isLeap(year)
+	ii := iterInfo{rrule: r}
+	ii.rebuild(year, month)
+	i := t.YearDay() - 1
+
+	if len(r.Bymonth) != 0 {
+		check("BYMONTH", contains(r.Bymonth, ii.mmask[i]), "month %d, allowed %v", ii.mmask[i], r.Bymonth)
+	}
+	if len(r.Byweekno) != 0 {
+		check("BYWEEKNO", ii.wnomask[i] != 0, "wnomask is %d, allowed weeks %v with WKST %v",
+			ii.wnomask[i], r.Byweekno, weekdays[r.Wkst])
+	}
+	if len(r.Byweekday) != 0 {
+		allowed := make([]Weekday, len(r.Byweekday))
+		for j, wday := range r.Byweekday {
+			allowed[j] = weekdays[wday]
+		}
+		check("BYDAY", contains(r.Byweekday, ii.wdaymask[i]), "weekday %v, allowed %v",
+			weekdays[ii.wdaymask[i]], allowed)
+	}
+	if len(r.Bynweekday) != 0 {
+		if len(ii.nwdaymask) != 0 {
+			check("BYDAY (nth)", ii.nwdaymask[i] != 0, "nwdaymask is %d, allowed %v", ii.nwdaymask[i], r.Bynweekday)
+		} else {
+			add("BYDAY (nth) %v is ignored for %v frequency.", r.Bynweekday, r.Freq)
+		}
+	}
+	if len(r.Byeaster) != 0 {
+		check("BYEASTER", ii.eastermask[i] != 0, "Easter is %v, offsets %v",
+			easter(year).Format("2006-01-02"), r.Byeaster)
+	}
+	if len(r.Bymonthday) != 0 || len(r.Bynmonthday) != 0 {
+		check("BYMONTHDAY", contains(r.Bymonthday, ii.mdaymask[i]) || contains(r.Bynmonthday, ii.nmdaymask[i]),
+			"day %d (or %d from the end), allowed %v", ii.mdaymask[i], ii.nmdaymask[i],
+			append(append([]int{}, r.Bymonthday...), r.Bynmonthday...))
+	}
+	if len(r.Byyearday) != 0 {
+		check("BYYEARDAY", contains(r.Byyearday, i+1) || contains(r.Byyearday, -yearlen+i),
+			"day %d (or %d from the end), allowed %v", i+1, -yearlen+i, r.Byyearday)
+	}
+	if len(r.Bysetpos) != 0 {
+		add("BYSETPOS %v further selects among the days of each period that passed the filters above.", r.Bysetpos)
+	}
+	if r.Count != 0 {
+		add("COUNT limits the rule to %d occurrences.", r.Count)
+	}
+
+	occurrence := r.After(t, true).Equal(t)
+	if passed && !occurrence {
+		add("All filters passed, so it was excluded by DTSTART, UNTIL, COUNT, INTERVAL or BYSETPOS.")
+	}
+	if occurrence {
+		add("Result: %v is an occurrence.", t)
+	} else {
+		add("Result: %v is NOT an occurrence.", t)
+	}
+	return strings.Join(lines, "\n")
+}
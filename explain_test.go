@@ -0,0 +1,42 @@
+package rrule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExplain(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: YEARLY, Bymonth: []int{2}, Bymonthday: []int{31},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	value := r.Explain(time.Date(1998, 2, 28, 9, 0, 0, 0, time.UTC))
+	for _, want := range []string{"BYMONTH passed", "BYMONTHDAY FAILED", "is NOT an occurrence"} {
+		if !strings.Contains(value, want) {
+			t.Errorf("get %q, want it to contain %q", value, want)
+		}
+	}
+
+	r, _ = NewRRule(ROption{Freq: MONTHLY, Byweekday: []Weekday{FR.Nth(-1)},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	value = r.Explain(time.Date(1997, 9, 26, 9, 0, 0, 0, time.UTC))
+	if !strings.Contains(value, "BYDAY (nth) passed") || !strings.Contains(value, "is an occurrence") {
+		t.Errorf("get %q, want an occurrence", value)
+	}
+}
+
+func TestExplainNoPanic(t *testing.T) {
+	dts := []time.Time{{}, time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(1997, 12, 31, 9, 0, 0, 0, time.UTC)}
+	for freq := YEARLY; freq <= SECONDLY; freq++ {
+		r, _ := NewRRule(ROption{Freq: freq, Count: 3, Byweekday: []Weekday{MO.Nth(1), TU},
+			Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+		for _, dt := range dts {
+			r.Explain(dt)
+		}
+	}
+	r, _ := NewRRule(ROption{Freq: YEARLY, Count: 3, Byweekno: []int{1, -1}, Byeaster: []int{-1, 1},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	for _, dt := range dts {
+		r.Explain(dt)
+	}
+}
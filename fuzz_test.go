@@ -0,0 +1,56 @@
+//go:build go1.18
+// +build go1.18
+
+package rrule
+
+import (
+	"testing"
+)
+
+func FuzzStrToRRule(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"    ",
+		"FREQ",
+		"FREQ=HELLO",
+		"BYMONTH=",
+		"FREQ=WEEKLY;HELLO=WORLD",
+		"FREQ=WEEKLY;BYMONTHDAY=I",
+		"FREQ=WEEKLY;BYDAY=M",
+		"FREQ=WEEKLY;BYDAY=MQ",
+		"FREQ=WEEKLY;BYDAY=+MO",
+		"BYDAY=MO",
+		"FREQ=WEEKLY;DTSTART=20120201T093000Z;INTERVAL=5;WKST=TU;COUNT=2;UNTIL=20130130T230000Z;BYSETPOS=2;BYMONTH=3;BYYEARDAY=95;BYWEEKNO=1;BYDAY=MO,+2FR;BYHOUR=9;BYMINUTE=30;BYSECOND=0;BYEASTER=-1",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		r, err := StrToRRule(s)
+		if err != nil {
+			return
+		}
+		_ = r.String()
+	})
+}
+
+func FuzzStrToRRuleSet(f *testing.F) {
+	for _, seed := range []string{
+		"RRULE:FREQ=DAILY;UNTIL=20180517T235959Z\n" +
+			"RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TU\n" +
+			"RRULE:FREQ=MONTHLY;UNTIL=20180520;BYMONTHDAY=1,2,3\n" +
+			"EXRULE:FREQ=WEEKLY;INTERVAL=4;BYDAY=MO\n" +
+			"EXDATE;VALUE=DATE-TIME:20180525T070000Z,20180530T130000Z\n" +
+			"RDATE;VALUE=DATE-TIME:20180801T131313Z,20180902T141414Z\n",
+		"DTSTART;TZID=America/New_York:20180101T090000\nRRULE:FREQ=MONTHLY",
+		"DTSTART:20180101T090000Z\nRDATE;VALUE=DATE:20180101",
+		"VALUE=DATE-TIME;TZID=America/New_York:20180101T090000,20180102T090000",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if set, err := StrToRRuleSet(s); err == nil {
+			_ = set.String()
+		}
+		_, _ = StrToDates(s)
+	})
+}
@@ -0,0 +1,19 @@
+//go:build go1.18
+// +build go1.18
+
+package rrule
+
+import "time"
+
+// ReduceT is the type-safe version of RRule.Reduce.
+func ReduceT[A any](r *RRule, initial A, fn func(A, time.Time) A) A {
+	next := r.Iterator()
+	acc := initial
+	for {
+		v, ok := next()
+		if !ok {
+			return acc
+		}
+		acc = fn(acc, v)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build go1.18
+// +build go1.18
+
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReduceT(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 14,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	mondays := ReduceT(r, 0, func(n int, t time.Time) int {
+		if t.Weekday() == time.Monday {
+			n++
+		}
+		return n
+	})
+	if mondays != 2 {
+		t.Errorf("get %d, want 2", mondays)
+	}
+	if last := ReduceT(r, time.Time{}, func(_, t time.Time) time.Time { return t }); !last.Equal(r.All()[13]) {
+		t.Errorf("get %v, want %v", last, r.All()[13])
+	}
+}
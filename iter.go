@@ -0,0 +1,45 @@
+//go:build go1.23
+// +build go1.23
+
+package rrule
+
+import (
+	"iter"
+	"time"
+)
+
+// AllLazy returns the occurrences of the rule as a sequence which generates
+// them one by one, as the iteration proceeds.
+func (r *RRule) AllLazy() iter.Seq[time.Time] {
+	return lazy(r.Iterator())
+}
+
+// AllLazy returns the occurrences of the set as a sequence which generates
+// them one by one, as the iteration proceeds.
+func (set *Set) AllLazy() iter.Seq[time.Time] {
+	return lazy(set.Iterator())
+}
+
+// SortedMergeLazy is same as Set.SortedMerge, but returns the occurrences as
+// a sequence which generates them one by one.
+func (set *Set) SortedMergeLazy(other *Set, before time.Time) iter.Seq[time.Time] {
+	next := mergeIterators(set.Iterator(), other.Iterator())
+	return lazy(func() (time.Time, bool) {
+		v, ok := next()
+		if !ok || v.After(before) {
+			return time.Time{}, false
+		}
+		return v, true
+	})
+}
+
+func lazy(next Next) iter.Seq[time.Time] {
+	return func(yield func(time.Time) bool) {
+		for {
+			v, ok := next()
+			if !ok || !yield(v) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+//go:build go1.23
+// +build go1.23
+
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllLazy(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 5,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	var value []time.Time
+	for v := range r.AllLazy() {
+		value = append(value, v)
+	}
+	if want := r.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	set := &Set{}
+	set.RRule(r)
+	set.ExDate(time.Date(1997, 9, 3, 9, 0, 0, 0, time.UTC))
+	value = nil
+	for v := range set.AllLazy() {
+		value = append(value, v)
+	}
+	if want := set.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	infinite, _ := NewRRule(ROption{Freq: DAILY,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	n := 0
+	for range infinite.AllLazy() {
+		if n++; n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Errorf("get %d iterations, want 3", n)
+	}
+}
+
+func TestSortedMergeLazy(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	daily, _ := NewRRule(ROption{Freq: DAILY, Dtstart: dtstart})
+	weekly, _ := NewRRule(ROption{Freq: WEEKLY, Dtstart: dtstart.Add(time.Hour)})
+	set, other := &Set{}, &Set{}
+	set.RRule(daily)
+	other.RRule(weekly)
+	before := dtstart.AddDate(0, 1, 0)
+
+	var value []time.Time
+	for v := range set.SortedMergeLazy(other, before) {
+		value = append(value, v)
+	}
+	if want, _ := set.SortedMerge(other, before); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+}
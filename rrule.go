@@ -1,9 +1,13 @@
 package rrule
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -42,6 +46,38 @@ func init() {
 	}
 }
 
+// WeekdayMask returns, for every day of year and the 7 days after it, the
+// index of its weekday counted from wkst, so that 0 is wkst and 6 is the day
+// before it. With wkst MO, it is the weekday mask used by the iterator,
+// where 0 is Monday.
+func WeekdayMask(year int, wkst Weekday) []int {
+	yearlen := 365 + isLeap(year)
+	first := toPyWeekday(time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC).Weekday())
+	mask := make([]int, yearlen+7)
+	for i := range mask {
+		mask[i] = pymod(WDAYMASK[first+i]-wkst.weekday, 7)
+	}
+	return mask
+}
+
+// MonthMask returns, for every day of year and the 7 days after it, its
+// month from 1 to 12.
+func MonthMask(year int) []int {
+	if isLeap(year) == 1 {
+		return copyInts(M366MASK)
+	}
+	return copyInts(M365MASK)
+}
+
+// MonthDayMask returns, for every day of year and the 7 days after it, its
+// day of month from 1 to 31.
+func MonthDayMask(year int) []int {
+	if isLeap(year) == 1 {
+		return copyInts(MDAY366MASK)
+	}
+	return copyInts(MDAY365MASK)
+}
+
 // Frequency denotes the period on which the rule is evaluated.
 type Frequency int
 
@@ -119,6 +155,28 @@ type ROption struct {
 	Bysecond   []int
 	Byeaster   []int
 	RFC        bool
+	// ExcludeWeekends restricts the rule to Monday to Friday, and
+	// ExcludeWeekdays to Saturday and Sunday, by filtering Byweekday, or
+	// setting it if it is empty. They are written as BYDAY by String. A
+	// MONTHLY or YEARLY rule without BYxxx parts keeps the day of the month,
+	// and month, of DTSTART, written as BYMONTHDAY and BYMONTH.
+	ExcludeWeekends bool
+	ExcludeWeekdays bool
+	// RScale is the calendar scale of the rule as defined by RFC 7529.
+	// Only the default, GREGORIAN, is supported.
+	RScale string
+	// WeekdayNames overrides the abbreviations of MO, TU, WE, TH, FR, SA
+	// and SU, in this order, used by StringWithWeekdayNames. String and the
+	// iCalendar serializations always use the RFC 5545 abbreviations.
+	WeekdayNames []string
+	// CountSet records that COUNT was given explicitly. A zero Count means
+	// no limit and is omitted by String unless CountSet is true. It is set
+	// when parsing a rule with a COUNT part.
+	CountSet bool
+	// GenerateInclusive makes DTSTART the first occurrence even if it does
+	// not match the rule, as RFC 5545 reads. It then counts towards COUNT.
+	// It is not written by String.
+	GenerateInclusive bool
 }
 
 // RRule offers a small, complete, and very fast, implementation of the recurrence rules
@@ -144,20 +202,33 @@ type RRule struct {
 	Bysecond                []int
 	Byeaster                []int
 	Timeset                 []time.Time
-	Len                     int
+	// Deprecated: Len used to be set by iteration, which prevented
+	// iterating a rule concurrently. It is no longer updated and is always
+	// zero. Use Total instead.
+	Len int
 }
 
 // NewRRule construct a new RRule instance
 func NewRRule(arg ROption) (*RRule, error) {
+	if arg.RScale != "" && !strings.EqualFold(arg.RScale, "GREGORIAN") {
+		return nil, ErrRScaleUnsupported
+	}
 	if err := validateBounds(arg); err != nil {
 		return nil, err
 	}
+	// Occurrences have a precision of a second, so DTSTART is truncated
+	// before it is recorded, for it to be equal to the first occurrence.
+	arg.Dtstart = arg.Dtstart.Truncate(time.Second)
+	dtstart := arg.Dtstart
+	if dtstart.IsZero() {
+		dtstart = time.Now().UTC().Truncate(time.Second)
+	}
+	if err := excludeWeekdays(&arg, dtstart); err != nil {
+		return nil, err
+	}
 	r := RRule{}
 	r.OrigOptions = arg
-	if arg.Dtstart.IsZero() {
-		arg.Dtstart = time.Now().UTC()
-	}
-	arg.Dtstart = arg.Dtstart.Truncate(time.Second)
+	arg.Dtstart = dtstart
 	r.DateStart = arg.Dtstart
 	r.Freq = arg.Freq
 	if arg.Interval == 0 {
@@ -168,7 +239,7 @@ func NewRRule(arg ROption) (*RRule, error) {
 	r.Count = arg.Count
 	if arg.Until.IsZero() {
 		// add largest representable duration (approximately 290 years).
-		arg.Until = r.DateStart.Add(time.Duration(1<<63 - 1))
+		arg.Until = r.DateStart.Add(MaxDuration)
 	}
 	r.UntilTime = arg.Until
 	r.Wkst = arg.Wkst.weekday
@@ -236,6 +307,80 @@ func NewRRule(arg ROption) (*RRule, error) {
 	return &r, nil
 }
 
+// Daily returns a rule recurring every n days from dtstart.
+func Daily(dtstart time.Time, n int) (*RRule, error) {
+	return newFactoryRRule(ROption{Freq: DAILY, Interval: n, Dtstart: dtstart})
+}
+
+// Weekly returns a rule recurring every n weeks from dtstart, on days, or
+// on the weekday of dtstart if days are omitted.
+func Weekly(dtstart time.Time, n int, days ...Weekday) (*RRule, error) {
+	return newFactoryRRule(ROption{Freq: WEEKLY, Interval: n, Byweekday: days, Dtstart: dtstart})
+}
+
+// Monthly returns a rule recurring every n months from dtstart, on the given
+// day of the month, which counts from the end of the month if negative.
+func Monthly(dtstart time.Time, n int, day int) (*RRule, error) {
+	return newFactoryRRule(ROption{Freq: MONTHLY, Interval: n, Bymonthday: []int{day}, Dtstart: dtstart})
+}
+
+// Yearly returns a rule recurring every n years from dtstart.
+func Yearly(dtstart time.Time, n int) (*RRule, error) {
+	return newFactoryRRule(ROption{Freq: YEARLY, Interval: n, Dtstart: dtstart})
+}
+
+// newFactoryRRule is NewRRule for the factories, which require a positive
+// interval.
+func newFactoryRRule(option ROption) (*RRule, error) {
+	if option.Interval < 1 {
+		return nil, fmt.Errorf("interval must be positive, got %d", option.Interval)
+	}
+	return NewRRule(option)
+}
+
+// clone returns a deep copy of the option.
+func (option ROption) clone() ROption {
+	option.Bysetpos = copyInts(option.Bysetpos)
+	option.Bymonth = copyInts(option.Bymonth)
+	option.Bymonthday = copyInts(option.Bymonthday)
+	option.Byyearday = copyInts(option.Byyearday)
+	option.Byweekno = copyInts(option.Byweekno)
+	if option.Byweekday != nil {
+		option.Byweekday = append([]Weekday{}, option.Byweekday...)
+	}
+	option.Byhour = copyInts(option.Byhour)
+	option.Byminute = copyInts(option.Byminute)
+	option.Bysecond = copyInts(option.Bysecond)
+	option.Byeaster = copyInts(option.Byeaster)
+	if option.WeekdayNames != nil {
+		option.WeekdayNames = append([]string{}, option.WeekdayNames...)
+	}
+	return option
+}
+
+// clone returns a deep copy of the RRule.
+func (r *RRule) clone() *RRule {
+	c := *r
+	c.OrigOptions = r.OrigOptions.clone()
+	c.Options = r.Options.clone()
+	c.Bysetpos = copyInts(r.Bysetpos)
+	c.Bymonth = copyInts(r.Bymonth)
+	c.Bymonthday = copyInts(r.Bymonthday)
+	c.Bynmonthday = copyInts(r.Bynmonthday)
+	c.Byyearday = copyInts(r.Byyearday)
+	c.Byweekno = copyInts(r.Byweekno)
+	c.Byweekday = copyInts(r.Byweekday)
+	if r.Bynweekday != nil {
+		c.Bynweekday = append([]Weekday{}, r.Bynweekday...)
+	}
+	c.Byhour = copyInts(r.Byhour)
+	c.Byminute = copyInts(r.Byminute)
+	c.Bysecond = copyInts(r.Bysecond)
+	c.Byeaster = copyInts(r.Byeaster)
+	c.Timeset = copyTimes(r.Timeset)
+	return &c
+}
+
 // validateBounds checks the RRule's options are within the boundaries defined
 // in RRFC 5545. This is useful to ensure that the RRule can even have any times,
 // as going outside these bounds trivially will never have any dates. This can catch
@@ -255,6 +400,7 @@ func validateBounds(arg ROption) error {
 		{arg.Byweekno, "Byweekno", []int{1, 53}, true},
 		{arg.Bymonth, "Bymonth", []int{1, 12}, false},
 		{arg.Bysetpos, "Bysetpos", []int{1, 366}, true},
+		{arg.Byeaster, "Byeaster", []int{-366, 366}, false},
 	}
 
 	checkBounds := func(param string, value int, bounds []int, plusMinus bool) error {
@@ -288,6 +434,48 @@ func validateBounds(arg ROption) error {
 		return errors.New("Interval must be greater than 0")
 	}
 
+	return checkWeekdayNames(arg.WeekdayNames)
+}
+
+// excludeWeekdays applies ExcludeWeekends and ExcludeWeekdays to the
+// Byweekday of option. Setting Byweekday of a MONTHLY or YEARLY rule would
+// drop the day of the month, and month, which NewRRule otherwise takes from
+// dtstart, so they are set first to restrict the rule instead of expanding
+// it.
+func excludeWeekdays(option *ROption, dtstart time.Time) error {
+	if !option.ExcludeWeekends && !option.ExcludeWeekdays {
+		return nil
+	}
+	if option.ExcludeWeekends && option.ExcludeWeekdays {
+		return errors.New("ExcludeWeekends and ExcludeWeekdays are mutually exclusive")
+	}
+	allowed := []Weekday{MO, TU, WE, TH, FR}
+	if option.ExcludeWeekdays {
+		allowed = []Weekday{SA, SU}
+	}
+	if len(option.Byweekday) == 0 {
+		if (option.Freq == YEARLY || option.Freq == MONTHLY) && len(option.Byweekno) == 0 &&
+			len(option.Byyearday) == 0 && len(option.Bymonthday) == 0 && len(option.Byeaster) == 0 {
+			if option.Freq == YEARLY && len(option.Bymonth) == 0 {
+				option.Bymonth = []int{int(dtstart.Month())}
+			}
+			option.Bymonthday = []int{dtstart.Day()}
+		}
+		option.Byweekday = allowed
+		return nil
+	}
+	var byweekday []Weekday
+	for _, wday := range option.Byweekday {
+		for _, a := range allowed {
+			if wday.weekday == a.weekday {
+				byweekday = append(byweekday, wday)
+			}
+		}
+	}
+	if len(byweekday) == 0 {
+		return errors.New("Byweekday has no day left after exclusion")
+	}
+	option.Byweekday = byweekday
 	return nil
 }
 
@@ -462,7 +650,10 @@ func (info *iterInfo) rebuild(year int, month time.Month) {
 		info.eastermask = make([]int, info.yearlen+7)
 		eyday := easter(year).YearDay() - 1
 		for _, offset := range info.rrule.Byeaster {
-			info.eastermask[eyday+offset] = 1
+			// Offsets moving out of the masked days are in another year.
+			if i := eyday + offset; i >= 0 && i < len(info.eastermask) {
+				info.eastermask[i] = 1
+			}
 		}
 	}
 	info.lastyear = year
@@ -546,6 +737,12 @@ type rIterator struct {
 	count    int
 	remain   []time.Time
 	finished bool
+	// maxYearReached is set when iteration stopped because of MAXYEAR, or
+	// because of the implicit UNTIL of a rule without one.
+	maxYearReached bool
+	// skipStart is set when DTSTART was added by GenerateInclusive and
+	// must not be generated again.
+	skipStart bool
 }
 
 func (iterator *rIterator) generate() {
@@ -608,16 +805,15 @@ func (iterator *rIterator) generate() {
 			sort.Sort(timeSlice(poslist))
 			for _, res := range poslist {
 				if !r.UntilTime.IsZero() && res.After(r.UntilTime) {
-					r.Len = iterator.total
 					iterator.finished = true
+					iterator.maxYearReached = r.implicitUntil()
 					return
-				} else if !res.Before(r.DateStart) {
+				} else if res.After(r.DateStart) || !iterator.skipStart && res.Equal(r.DateStart) {
 					iterator.total++
 					iterator.remain = append(iterator.remain, res)
 					if iterator.count != 0 {
 						iterator.count--
 						if iterator.count == 0 {
-							r.Len = iterator.total
 							iterator.finished = true
 							return
 						}
@@ -635,16 +831,15 @@ func (iterator *rIterator) generate() {
 						timeTemp.Hour(), timeTemp.Minute(), timeTemp.Second(),
 						timeTemp.Nanosecond(), timeTemp.Location())
 					if !r.UntilTime.IsZero() && res.After(r.UntilTime) {
-						r.Len = iterator.total
 						iterator.finished = true
+						iterator.maxYearReached = r.implicitUntil()
 						return
-					} else if !res.Before(r.DateStart) {
+					} else if res.After(r.DateStart) || !iterator.skipStart && res.Equal(r.DateStart) {
 						iterator.total++
 						iterator.remain = append(iterator.remain, res)
 						if iterator.count != 0 {
 							iterator.count--
 							if iterator.count == 0 {
-								r.Len = iterator.total
 								iterator.finished = true
 								return
 							}
@@ -658,8 +853,8 @@ func (iterator *rIterator) generate() {
 		if r.Freq == YEARLY {
 			iterator.year += r.Interval
 			if iterator.year > MAXYEAR {
-				r.Len = iterator.total
 				iterator.finished = true
+				iterator.maxYearReached = true
 				return
 			}
 			iterator.ii.rebuild(iterator.year, iterator.month)
@@ -674,8 +869,8 @@ func (iterator *rIterator) generate() {
 					iterator.year--
 				}
 				if iterator.year > MAXYEAR {
-					r.Len = iterator.total
 					iterator.finished = true
+					iterator.maxYearReached = true
 					return
 				}
 			}
@@ -775,8 +970,8 @@ func (iterator *rIterator) generate() {
 						iterator.month = 1
 						iterator.year++
 						if iterator.year > MAXYEAR {
-							r.Len = iterator.total
 							iterator.finished = true
+							iterator.maxYearReached = true
 							return
 						}
 					}
@@ -801,9 +996,19 @@ func (iterator *rIterator) next() (time.Time, bool) {
 	return value, true
 }
 
+// len returns the number of occurrences generated so far, which is the
+// total number of occurrences once iteration is finished.
+func (iterator *rIterator) len() int {
+	return iterator.total
+}
+
 // Iterator return an iterator for RRule
 func (r *RRule) Iterator() Next {
-	iterator := rIterator{}
+	return r.iterator().next
+}
+
+func (r *RRule) iterator() *rIterator {
+	iterator := &rIterator{}
 	iterator.year, iterator.month, iterator.day = r.DateStart.Date()
 	iterator.hour, iterator.minute, iterator.second = r.DateStart.Clock()
 	iterator.weekday = toPyWeekday(r.DateStart.Weekday())
@@ -823,14 +1028,125 @@ func (r *RRule) Iterator() Next {
 		}
 	}
 	iterator.count = r.Count
-	return iterator.next
+	if r.OrigOptions.GenerateInclusive {
+		iterator.skipStart = true
+		iterator.total = 1
+		iterator.remain = []time.Time{r.DateStart}
+		if iterator.count != 0 {
+			iterator.count--
+			iterator.finished = iterator.count == 0
+		}
+	}
+	return iterator
+}
+
+// iteratorFrom returns an iterator which skips whole periods of the rule
+// ending before dt where that can be done arithmetically. Occurrences before dt
+// may still be generated. Rules with COUNT or a frequency finer than DAILY
+// are iterated from DTSTART.
+func (r *RRule) iteratorFrom(dt time.Time) *rIterator {
+	iterator := r.iterator()
+	if r.Count != 0 || r.Freq > DAILY {
+		return iterator
+	}
+	dt = dt.In(r.DateStart.Location())
+	year, month, day := r.DateStart.Date()
+	switch r.Freq {
+	case YEARLY:
+		k := (dt.Year() - year) / r.Interval
+		if k <= 0 {
+			return iterator
+		}
+		iterator.year = year + k*r.Interval
+	case MONTHLY:
+		k := ((dt.Year()-year)*12 + int(dt.Month()-month)) / r.Interval
+		if k <= 0 {
+			return iterator
+		}
+		months := int(month) - 1 + k*r.Interval
+		iterator.year, iterator.month = year+months/12, time.Month(months%12+1)
+	case WEEKLY:
+		// Periods after the first one start on Wkst.
+		weekstart := day - pymod(iterator.weekday-r.Wkst, 7)
+		k := daysBetween(time.Date(year, month, weekstart, 0, 0, 0, 0, time.UTC), dt) / (7 * r.Interval)
+		if k <= 0 {
+			return iterator
+		}
+		iterator.year, iterator.month, iterator.day = time.Date(year, month, weekstart+k*7*r.Interval, 0, 0, 0, 0, time.UTC).Date()
+		iterator.weekday = r.Wkst
+	case DAILY:
+		k := daysBetween(time.Date(year, month, day, 0, 0, 0, 0, time.UTC), dt) / r.Interval
+		if k <= 0 {
+			return iterator
+		}
+		iterator.year, iterator.month, iterator.day = time.Date(year, month, day+k*r.Interval, 0, 0, 0, 0, time.UTC).Date()
+	}
+	if iterator.year > MAXYEAR {
+		iterator.finished = true
+		iterator.maxYearReached = true
+		return iterator
+	}
+	iterator.ii.rebuild(iterator.year, iterator.month)
+	return iterator
 }
 
 // All returns all occurrences of the RRule.
+// Iteration silently stops at MAXYEAR; use AllSafe to detect that.
 func (r *RRule) All() []time.Time {
 	return all(r.Iterator())
 }
 
+// AllSafe is like All but returns ErrMaxYearExceeded along with the
+// occurrences found so far if iteration was stopped by reaching MAXYEAR, or
+// by the implicit UNTIL, about 292 years after DTSTART, of a rule without
+// COUNT or UNTIL.
+func (r *RRule) AllSafe() ([]time.Time, error) {
+	iterator := r.iterator()
+	result := all(iterator.next)
+	if iterator.maxYearReached {
+		return result, ErrMaxYearExceeded
+	}
+	return result, nil
+}
+
+// Total returns the number of occurrences of the rule. Like All, it iterates
+// over every occurrence, and it does not modify the rule. It replaces the
+// Len field, which iteration used to update, preventing concurrent use.
+// A rule without COUNT or UNTIL is iterated up to its implicit UNTIL, about
+// 292 years after DTSTART, or MAXYEAR, and Total returns the number of
+// occurrences until then; AllSafe reports when that happens.
+func (r *RRule) Total() int {
+	iterator := r.iterator()
+	for {
+		if _, ok := iterator.next(); !ok {
+			return iterator.len()
+		}
+	}
+}
+
+// Materialize returns at most the first limit occurrences of the rule.
+// truncated reports whether the rule has more occurrences than returned.
+func (r *RRule) Materialize(limit int) (occurrences []time.Time, truncated bool) {
+	if limit < 0 {
+		limit = 0
+	}
+	size := limit
+	if r.Count != 0 && r.Count < size {
+		size = r.Count
+	}
+	occurrences = make([]time.Time, 0, size)
+	next := r.Iterator()
+	for len(occurrences) < limit {
+		v, ok := next()
+		if !ok {
+			return occurrences, false
+		}
+		occurrences = append(occurrences, v)
+	}
+	_, truncated = next()
+	return occurrences, truncated
+}
+
 // Between returns all the occurrences of the RRule between after and before.
 // The inc keyword defines what happens if after and/or before are themselves occurrences.
 // With inc == True, they will be included in the list, if they are found in the recurrence set.
@@ -838,6 +1154,145 @@ func (r *RRule) Between(after, before time.Time, inc bool) []time.Time {
 	return between(r.Iterator(), after, before, inc)
 }
 
+// AllInTimezone returns the occurrences of the RRule whose wall-clock date
+// in loc is in the given month of the given year, whatever the offsets of
+// loc at the bounds of the month. loc is only used for the bounds:
+// occurrences are returned in the location of the rule.
+func (r *RRule) AllInTimezone(loc *time.Location, year int, month time.Month) []time.Time {
+	start := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	end := time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+	result := []time.Time{}
+	for _, v := range all(betweenIterator(r.iteratorFrom(start).next, start, end, true)) {
+		if y, m, _ := v.In(loc).Date(); y == year && m == month {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// AllAfter returns all the occurrences of the RRule after the given time,
+// which is included if inc is true and it is an occurrence. Like All, it
+// stops at MAXYEAR for rules without COUNT or UNTIL.
+func (r *RRule) AllAfter(after time.Time, inc bool) []time.Time {
+	return allAfter(r.iteratorFrom(after).next, after, inc)
+}
+
+// FirstN returns the first n occurrences of the RRule, or all of them if it
+// has fewer. The first one is DTSTART only if DTSTART matches the rule.
+func (r *RRule) FirstN(n int) []time.Time {
+	return all(truncateIterator(r.Iterator(), n))
+}
+
+// SliceFrom returns the first n occurrences of the RRule at or after from,
+// or fewer if the rule ends. Whole periods before from are skipped without
+// being generated for rules without COUNT and a frequency of DAILY or
+// coarser, so the cost does not grow with the distance from DTSTART.
+func (r *RRule) SliceFrom(from time.Time, n int) []time.Time {
+	result, _ := r.GenerateN(from, n)
+	return result
+}
+
+// GenerateN returns exactly the first n occurrences of the RRule at or
+// after from. If the rule ends before n occurrences, the ones found are
+// returned with an error wrapping ErrInsufficientOccurrences, which reports
+// how many were found.
+func (r *RRule) GenerateN(from time.Time, n int) ([]time.Time, error) {
+	result := []time.Time{}
+	next := r.iteratorFrom(from).next
+	for len(result) < n {
+		v, ok := next()
+		if !ok {
+			return result, fmt.Errorf("%w: got %d of %d", ErrInsufficientOccurrences, len(result), n)
+		}
+		if !v.Before(from) {
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+// AllBefore returns all the occurrences of the RRule before the given time,
+// which is included if inc is true and it is an occurrence.
+func (r *RRule) AllBefore(before time.Time, inc bool) []time.Time {
+	return allBefore(r.Iterator(), before, inc)
+}
+
+// AllBetweenWithContext is same as Between, but stops once ctx is done and
+// returns the occurrences found so far along with the error of ctx.
+func (r *RRule) AllBetweenWithContext(ctx context.Context, after, before time.Time, inc bool) ([]time.Time, error) {
+	var err error
+	result := between(contextIterator(ctx, r.iteratorFrom(after).next, &err), after, before, inc)
+	return result, err
+}
+
+// BetweenCount returns the number of occurrences Between would return,
+// without collecting them.
+func (r *RRule) BetweenCount(after, before time.Time, inc bool) int {
+	return betweenCount(r.iteratorFrom(after).next, after, before, inc)
+}
+
+// ContainsAll reports whether every time in times is an occurrence of the
+// RRule. The times are checked in a single pass over the occurrences.
+func (r *RRule) ContainsAll(times []time.Time) bool {
+	return r.matchTimes(times) == len(times)
+}
+
+// ContainsAny reports whether at least one time in times is an occurrence
+// of the RRule.
+func (r *RRule) ContainsAny(times []time.Time) bool {
+	return r.matchTimes(times) != 0
+}
+
+// matchTimes returns how many of times are occurrences of the RRule.
+func (r *RRule) matchTimes(times []time.Time) int {
+	if len(times) == 0 {
+		return 0
+	}
+	sorted := append([]time.Time(nil), times...)
+	sort.Sort(timeSlice(sorted))
+	next := r.iteratorFrom(sorted[0]).next
+	matched := 0
+	v, ok := next()
+	for _, t := range sorted {
+		for ok && v.Before(t) {
+			v, ok = next()
+		}
+		if !ok {
+			break
+		}
+		if v.Equal(t) {
+			matched++
+		}
+	}
+	return matched
+}
+
+// AllWeekdays returns the occurrences between after and before, both
+// included, grouped by their weekday in the time zone of the occurrences.
+// Weekdays without occurrences are not in the result.
+func (r *RRule) AllWeekdays(after, before time.Time) map[time.Weekday][]time.Time {
+	result := map[time.Weekday][]time.Time{}
+	for _, t := range r.Between(after, before, true) {
+		result[t.Weekday()] = append(result[t.Weekday()], t)
+	}
+	return result
+}
+
+// AllBetweenPage returns at most limit occurrences between after and before,
+// skipping the first offset of them, along with the total number of
+// occurrences between after and before. The inc keyword is as for Between.
+func (r *RRule) AllBetweenPage(after, before time.Time, inc bool, offset, limit int) ([]time.Time, int) {
+	total := r.BetweenCount(after, before, inc)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= total || limit <= 0 {
+		return []time.Time{}, total
+	}
+	next := betweenIterator(r.iteratorFrom(after).next, after, before, inc)
+	return all(truncateIterator(skipIterator(next, offset), limit)), total
+}
+
 // Before returns the last recurrence before the given datetime instance,
 // or time.Time's zero value if no recurrence match.
 // The inc keyword defines what happens if dt is an occurrence.
@@ -854,10 +1309,41 @@ func (r *RRule) After(dt time.Time, inc bool) time.Time {
 	return after(r.Iterator(), dt, inc)
 }
 
+// NextBusinessDay returns the first occurrence strictly after from which
+// falls on Monday to Friday, or time.Time's zero value if there is none.
+func (r *RRule) NextBusinessDay(from time.Time) time.Time {
+	if days := r.NextNBusinessDays(from, 1); len(days) != 0 {
+		return days[0]
+	}
+	return time.Time{}
+}
+
+// NextNBusinessDays returns the first n occurrences strictly after from
+// which fall on Monday to Friday, or all of them if there are fewer.
+func (r *RRule) NextNBusinessDays(from time.Time, n int) []time.Time {
+	result := []time.Time{}
+	next := r.iteratorFrom(from).next
+	for len(result) < n {
+		v, ok := next()
+		if !ok {
+			break
+		}
+		if v.After(from) && v.Weekday() != time.Saturday && v.Weekday() != time.Sunday {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
 // DTStart set a new DTStart for the rule and recalculates the Timeset if needed.
 func (r *RRule) DTStart(dt time.Time) {
+	implicit := r.implicitUntil()
 	r.DateStart = dt.Truncate(time.Second)
 	r.Options.Dtstart = r.DateStart
+	if implicit {
+		r.UntilTime = r.DateStart.Add(MaxDuration)
+		r.Options.Until = r.UntilTime
+	}
 
 	if len(r.Options.Byhour) == 0 && r.Freq < HOURLY {
 		r.Byhour = []int{r.DateStart.Hour()}
@@ -872,12 +1358,96 @@ func (r *RRule) DTStart(dt time.Time) {
 	r.calculateTimeset()
 }
 
+// implicitUntil reports whether UntilTime is the bound NewRRule sets for a
+// rule without UNTIL rather than one given by the caller.
+func (r *RRule) implicitUntil() bool {
+	return r.OrigOptions.Until.IsZero() && r.UntilTime.Sub(r.DateStart) == MaxDuration
+}
+
 // Until set a new Until for the rule and recalculates the Timeset if needed.
 func (r *RRule) Until(ut time.Time) {
 	r.UntilTime = ut
 	r.Options.Until = ut
 }
 
+// equivalenceMaxSample is the maximum number of occurrences compared by
+// IsEquivalentTo.
+const equivalenceMaxSample = 1000
+
+// IsEquivalentTo reports whether r and other generate the same occurrences
+// during sampleWindow, one year if it is zero, from the earlier of their
+// DTSTARTs. At most the first 1000 occurrences of each rule are compared.
+func (r *RRule) IsEquivalentTo(other *RRule, sampleWindow time.Duration) bool {
+	if sampleWindow == 0 {
+		sampleWindow = 365 * 24 * time.Hour
+	}
+	start := r.DateStart
+	if other.DateStart.Before(start) {
+		start = other.DateStart
+	}
+	end := start.Add(sampleWindow)
+	sample := func(r *RRule) []time.Time {
+		return all(truncateIterator(betweenIterator(r.Iterator(), start, end, true), equivalenceMaxSample))
+	}
+	a, b := sample(r), sample(other)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash returns the 64-bit FNV-1a hash of a canonical encoding of the rule,
+// for use as a cache key. The encoding is made of the effective values of
+// the rule parts, with DTSTART and defaulted parts filled in and lists
+// sorted, so rules with the same effective parts have the same hash however
+// they were constructed or written, except for the RFC flag, which is part
+// of the encoding. It does not depend on the output of String.
+func (r *RRule) Hash() uint64 {
+	h := fnv.New64a()
+	until := ""
+	if !r.OrigOptions.Until.IsZero() {
+		until = r.UntilTime.UTC().Format(time.RFC3339)
+	}
+	fmt.Fprintf(h, "%d;%s;%s;%d;%d;%d;%s;%t;%t", r.Freq, r.DateStart.Format(time.RFC3339), r.DateStart.Location(),
+		r.Interval, r.Wkst, r.Count, until, r.OrigOptions.GenerateInclusive, r.OrigOptions.RFC)
+	for _, ints := range [][]int{r.Bysetpos, r.Bymonth, r.Bymonthday, r.Bynmonthday, r.Byyearday,
+		r.Byweekno, r.Byweekday, r.Byhour, r.Byminute, r.Bysecond, r.Byeaster} {
+		sorted := copyInts(ints)
+		sort.Ints(sorted)
+		fmt.Fprintf(h, ";%v", sorted)
+	}
+	fmt.Fprintf(h, ";%v", canonicalWeekdays(r.Bynweekday))
+	return h.Sum64()
+}
+
+// TimeZone returns the location occurrences of the rule are generated in,
+// which is the location of DTSTART.
+func (r *RRule) TimeZone() *time.Location {
+	return r.DateStart.Location()
+}
+
+// WithTimezone returns a copy of the rule whose DTSTART has the same
+// wall-clock time as the one of r, in loc. UNTIL is converted the same way
+// from the time zone of DTSTART, so that occurrences keep their wall-clock
+// times. Unlike time.Time.In, the instants of occurrences change. An error
+// is returned if loc is nil.
+func (r *RRule) WithTimezone(loc *time.Location) (*RRule, error) {
+	if loc == nil {
+		return nil, errors.New("location is nil")
+	}
+	return r.with(func(option *ROption) {
+		option.Dtstart = inLocation(option.Dtstart, loc)
+		if !option.Until.IsZero() {
+			option.Until = inLocation(option.Until.In(r.DateStart.Location()), loc)
+		}
+	})
+}
+
 // calculateTimeset calculates the Timeset if needed.
 func (r *RRule) calculateTimeset() {
 	// Reset the Timeset value
@@ -894,3 +1464,615 @@ func (r *RRule) calculateTimeset() {
 		sort.Sort(timeSlice(r.Timeset))
 	}
 }
+
+// Gaps is a list of durations between consecutive occurrences.
+type Gaps []time.Duration
+
+// MaxGap returns the longest gap, or 0 if there are no gaps.
+func (g Gaps) MaxGap() time.Duration {
+	var result time.Duration
+	for i, d := range g {
+		if i == 0 || d > result {
+			result = d
+		}
+	}
+	return result
+}
+
+// MinGap returns the shortest gap, or 0 if there are no gaps.
+func (g Gaps) MinGap() time.Duration {
+	var result time.Duration
+	for i, d := range g {
+		if i == 0 || d < result {
+			result = d
+		}
+	}
+	return result
+}
+
+// AverageGap returns the mean gap, or 0 if there are no gaps.
+func (g Gaps) AverageGap() time.Duration {
+	if len(g) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range g {
+		total += d
+	}
+	return total / time.Duration(len(g))
+}
+
+// Gaps returns the durations between chronologically adjacent occurrences
+// of the RRule between after and before (both inclusive).
+func (r *RRule) Gaps(after, before time.Time) Gaps {
+	return gaps(r.Between(after, before, true))
+}
+
+// regularSampleSize is the number of occurrences IsRegular inspects.
+const regularSampleSize = 1000
+
+// IsRegular returns true if all gaps between consecutive occurrences are equal.
+// Only the first occurrences of the rule are inspected, so rules which become
+// irregular far in the future may still be reported as regular.
+func (r *RRule) IsRegular() bool {
+	next := r.Iterator()
+	var prev time.Time
+	var gap time.Duration
+	for i := 0; i < regularSampleSize; i++ {
+		v, ok := next()
+		if !ok {
+			break
+		}
+		if i >= 2 && v.Sub(prev) != gap {
+			return false
+		}
+		if i >= 1 {
+			gap = v.Sub(prev)
+		}
+		prev = v
+	}
+	return true
+}
+
+// Window groups all occurrences of the RRule into windows of windowSize,
+// the kth window covering [DTSTART+k*step, DTSTART+k*step+windowSize).
+// Windows overlap when step is less than windowSize. Windows are generated
+// until the last occurrence is covered; empty windows are included.
+func (r *RRule) Window(windowSize, step time.Duration) [][]time.Time {
+	times := r.All()
+	if len(times) == 0 {
+		return [][]time.Time{}
+	}
+	return windows(times, r.DateStart, times[len(times)-1].Add(1), windowSize, step)
+}
+
+// WindowBetween is like Window but only considers occurrences between after
+// and before (both inclusive), with the first window starting at after.
+func (r *RRule) WindowBetween(after, before time.Time, windowSize, step time.Duration) [][]time.Time {
+	return windows(r.Between(after, before, true), after, before.Add(1), windowSize, step)
+}
+
+// ActiveDuring returns true if the RRule has at least one occurrence between
+// start and end (both inclusive). Whole periods before start are skipped
+// without being generated when the rule allows it.
+func (r *RRule) ActiveDuring(start, end time.Time) bool {
+	next := r.iteratorFrom(start).next
+	for {
+		v, ok := next()
+		if !ok || v.After(end) {
+			return false
+		}
+		if !v.Before(start) {
+			return true
+		}
+	}
+}
+
+// Split forks the RRule at the occurrence at into a rule generating the
+// occurrences before at and a rule starting at at. Concatenating the
+// occurrences of both rules gives the occurrences of the original rule:
+// a COUNT is divided between both parts and an UNTIL is kept by the second.
+// An error is returned if at is not an occurrence or is the first one.
+func (r *RRule) Split(at time.Time) (*RRule, *RRule, error) {
+	at = at.Truncate(time.Second)
+	if !r.After(at, true).Equal(at) {
+		return nil, nil, fmt.Errorf("%v is not an occurrence", at)
+	}
+	prev := r.Before(at, false)
+	if prev.IsZero() {
+		return nil, nil, errors.New("cannot split at the first occurrence")
+	}
+
+	first, second := r.OrigOptions.clone(), r.OrigOptions.clone()
+	first.Dtstart = r.DateStart
+	second.Dtstart = at
+	if r.Count != 0 {
+		n := len(r.Between(r.DateStart, at, true)) - 1
+		first.Count = n
+		second.Count = r.Count - n
+	} else {
+		first.Until = prev
+	}
+
+	before, err := NewRRule(first)
+	if err != nil {
+		return nil, nil, err
+	}
+	after, err := NewRRule(second)
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+// AllOnDay returns all occurrences of the RRule on the given date in the
+// rule's time zone. Whole periods before the date are skipped without being
+// generated when the rule allows it.
+func (r *RRule) AllOnDay(year int, month time.Month, day int) []time.Time {
+	start := time.Date(year, month, day, 0, 0, 0, 0, r.DateStart.Location())
+	end := start.AddDate(0, 0, 1)
+	return between(r.iteratorFrom(start).next, start, end.Add(-time.Nanosecond), true)
+}
+
+// AllInWeek returns all occurrences of the RRule in the given week of year,
+// in the rule's time zone. Weeks start on the rule's Wkst and, as in RFC 5545,
+// week 1 is the first week containing at least four days of the year,
+// so it may start in the previous year.
+func (r *RRule) AllInWeek(year, week int) []time.Time {
+	if week < 1 || week > 53 {
+		return []time.Time{}
+	}
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, r.DateStart.Location())
+	offset := pymod(toPyWeekday(jan4.Weekday())-r.Wkst, 7)
+	start := time.Date(year, time.January, 4-offset+(week-1)*7, 0, 0, 0, 0, r.DateStart.Location())
+	end := start.AddDate(0, 0, 7)
+	return between(r.iteratorFrom(start).next, start, end.Add(-time.Nanosecond), true)
+}
+
+// NthOccurrenceAfter returns the nth occurrence strictly after from,
+// n = 1 being the next occurrence. It returns false if there are fewer
+// than n such occurrences.
+func (r *RRule) NthOccurrenceAfter(n int, from time.Time) (time.Time, bool) {
+	if n < 1 {
+		return time.Time{}, false
+	}
+	next := r.iteratorFrom(from).next
+	for {
+		v, ok := next()
+		if !ok {
+			return time.Time{}, false
+		}
+		if v.After(from) {
+			n--
+			if n == 0 {
+				return v, true
+			}
+		}
+	}
+}
+
+// NthOccurrenceBefore returns the nth occurrence strictly before before,
+// n = 1 being the previous occurrence. It returns false if there are fewer
+// than n such occurrences.
+func (r *RRule) NthOccurrenceBefore(n int, before time.Time) (time.Time, bool) {
+	last := r.lastN(before, n)
+	if n < 1 || len(last) < n {
+		return time.Time{}, false
+	}
+	return last[0], true
+}
+
+// AllFuture returns the next n occurrences after the current time, or all
+// of them if there are fewer.
+func (r *RRule) AllFuture(n int) []time.Time {
+	return r.nextN(time.Now(), n)
+}
+
+// AllPast returns the n most recent occurrences before the current time,
+// in chronological order, or all of them if there are fewer.
+func (r *RRule) AllPast(n int) []time.Time {
+	return r.lastN(time.Now(), n)
+}
+
+// IsCurrentlyRecurring reports whether the current time is between DTSTART
+// and the end of the rule, which is UNTIL, or the last occurrence for rules
+// with COUNT, whether or not it is an occurrence itself.
+func (r *RRule) IsCurrentlyRecurring() bool {
+	return r.recurringAt(time.Now())
+}
+
+func (r *RRule) nextN(from time.Time, n int) []time.Time {
+	result := []time.Time{}
+	next := r.iteratorFrom(from).next
+	for len(result) < n {
+		v, ok := next()
+		if !ok {
+			break
+		}
+		if v.After(from) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func (r *RRule) lastN(before time.Time, n int) []time.Time {
+	if n < 1 {
+		return []time.Time{}
+	}
+	// Keep the last n occurrences in a ring buffer.
+	ring := make([]time.Time, n)
+	count := 0
+	next := r.Iterator()
+	for {
+		v, ok := next()
+		if !ok || !v.Before(before) {
+			break
+		}
+		ring[count%n] = v
+		count++
+	}
+	if count < n {
+		return ring[:count]
+	}
+	return append(ring[count%n:], ring[:count%n]...)
+}
+
+func (r *RRule) recurringAt(t time.Time) bool {
+	if t.Before(r.DateStart) {
+		return false
+	}
+	end := r.UntilTime
+	if r.Count != 0 {
+		end = r.Before(end, true)
+	}
+	return !t.After(end)
+}
+
+// AllExcept returns all occurrences of the RRule which are not occurrences
+// of any rule in exclusions, like the All of a Set with exclusions as
+// EXRULEs. Neither r nor exclusions are modified.
+func (r *RRule) AllExcept(exclusions []*RRule) []time.Time {
+	nexts := make([]Next, len(exclusions))
+	for i, ex := range exclusions {
+		nexts[i] = ex.Iterator()
+	}
+	return all(exceptIterator(r.Iterator(), nexts...))
+}
+
+// AllExceptBetween is like AllExcept, but only returns the occurrences
+// between after and before, as Between does.
+func (r *RRule) AllExceptBetween(exclusions []*RRule, after, before time.Time, inc bool) []time.Time {
+	nexts := make([]Next, len(exclusions))
+	for i, ex := range exclusions {
+		nexts[i] = ex.iteratorFrom(after).next
+	}
+	return all(exceptIterator(betweenIterator(r.iteratorFrom(after).next, after, before, inc), nexts...))
+}
+
+// with returns a new rule built from the options of r, with DTSTART kept,
+// after applying modify to them.
+func (r *RRule) with(modify func(option *ROption)) (*RRule, error) {
+	option := r.OrigOptions.clone()
+	option.Dtstart = r.DateStart
+	modify(&option)
+	return NewRRule(option)
+}
+
+// WithBymonth returns a copy of the rule with Bymonth replaced by months, or an
+// error if they are out of bounds.
+func (r *RRule) WithBymonth(months ...int) (*RRule, error) {
+	return r.with(func(option *ROption) { option.Bymonth = months })
+}
+
+// WithBymonthday returns a copy of the rule with Bymonthday replaced by days, or an
+// error if they are out of bounds.
+func (r *RRule) WithBymonthday(days ...int) (*RRule, error) {
+	return r.with(func(option *ROption) { option.Bymonthday = days })
+}
+
+// WithByyearday returns a copy of the rule with Byyearday replaced by days, or an
+// error if they are out of bounds.
+func (r *RRule) WithByyearday(days ...int) (*RRule, error) {
+	return r.with(func(option *ROption) { option.Byyearday = days })
+}
+
+// WithByweekno returns a copy of the rule with Byweekno replaced by weeks, or an
+// error if they are out of bounds.
+func (r *RRule) WithByweekno(weeks ...int) (*RRule, error) {
+	return r.with(func(option *ROption) { option.Byweekno = weeks })
+}
+
+// WithByweekday returns a copy of the rule with Byweekday replaced by days, or an
+// error if they are out of bounds.
+func (r *RRule) WithByweekday(days ...Weekday) (*RRule, error) {
+	return r.with(func(option *ROption) { option.Byweekday = days })
+}
+
+// WithByhour returns a copy of the rule with Byhour replaced by hours, or an
+// error if they are out of bounds.
+func (r *RRule) WithByhour(hours ...int) (*RRule, error) {
+	return r.with(func(option *ROption) { option.Byhour = hours })
+}
+
+// WithByminute returns a copy of the rule with Byminute replaced by minutes, or an
+// error if they are out of bounds.
+func (r *RRule) WithByminute(minutes ...int) (*RRule, error) {
+	return r.with(func(option *ROption) { option.Byminute = minutes })
+}
+
+// WithBysecond returns a copy of the rule with Bysecond replaced by seconds, or an
+// error if they are out of bounds.
+func (r *RRule) WithBysecond(seconds ...int) (*RRule, error) {
+	return r.with(func(option *ROption) { option.Bysecond = seconds })
+}
+
+// WithBysetpos returns a copy of the rule with Bysetpos replaced by positions, or an
+// error if they are out of bounds.
+func (r *RRule) WithBysetpos(positions ...int) (*RRule, error) {
+	return r.with(func(option *ROption) { option.Bysetpos = positions })
+}
+
+// WithByeaster returns a copy of the rule with Byeaster replaced by offsets, or an
+// error if they are out of bounds.
+func (r *RRule) WithByeaster(offsets ...int) (*RRule, error) {
+	return r.with(func(option *ROption) { option.Byeaster = offsets })
+}
+
+// averagePeriod returns the average length of a period of the frequency.
+func averagePeriod(freq Frequency) time.Duration {
+	return [...]time.Duration{
+		time.Duration(365.2425 * 24 * float64(time.Hour)),
+		time.Duration(365.2425 * 24 / 12 * float64(time.Hour)),
+		7 * 24 * time.Hour,
+		24 * time.Hour,
+		time.Hour,
+		time.Minute,
+		time.Second,
+	}[freq]
+}
+
+// FrequencyInterval returns the duration of one period of the RRule,
+// that is Interval times the duration of its frequency. Months count as
+// 30 days and years as 365 days, so the result is only approximate for
+// MONTHLY and YEARLY rules.
+func (r *RRule) FrequencyInterval() time.Duration {
+	period := [...]time.Duration{
+		365 * 24 * time.Hour,
+		30 * 24 * time.Hour,
+		7 * 24 * time.Hour,
+		24 * time.Hour,
+		time.Hour,
+		time.Minute,
+		time.Second,
+	}[r.Freq]
+	return time.Duration(r.Interval) * period
+}
+
+// EstimateCount returns an approximation of the number of occurrences of the
+// RRule before the given time, computed arithmetically from the frequency,
+// interval and BYXXX filters without generating any occurrence.
+// The estimate is exact for rules without BYXXX filters, but only approximate
+// for constrained ones. It returns -1 for rules using BYEASTER, BYWEEKNO or
+// BYYEARDAY, which it cannot estimate.
+func (r *RRule) EstimateCount(before time.Time) int {
+	if len(r.Byeaster) != 0 || len(r.Byweekno) != 0 || len(r.Byyearday) != 0 {
+		return -1
+	}
+	end := before
+	if !r.UntilTime.IsZero() && r.UntilTime.Before(end) {
+		end = r.UntilTime.Add(time.Second)
+	}
+	if !end.After(r.DateStart) {
+		return 0
+	}
+
+	perPeriod := r.perPeriod()
+	period := averagePeriod(r.Freq).Seconds() * float64(r.Interval)
+	elapsed := float64(end.Unix() - r.DateStart.Unix())
+	estimate := int(math.Ceil(elapsed / period * perPeriod))
+	if r.Count != 0 && estimate > r.Count {
+		estimate = r.Count
+	}
+	if estimate < 0 {
+		estimate = 0
+	}
+	return estimate
+}
+
+// perPeriod returns the average number of occurrences per period of the
+// RRule's frequency, derived from its BYXXX filters.
+func (r *RRule) perPeriod() float64 {
+	// Average number of days per month matching the day filters.
+	monthdays := 365.2425 / 12
+	if n := len(r.Bymonthday) + len(r.Bynmonthday); n != 0 {
+		monthdays = float64(n)
+	} else if len(r.Byweekday) != 0 {
+		monthdays *= float64(len(r.Byweekday)) / 7
+	} else if len(r.Bynweekday) != 0 {
+		monthdays = float64(len(r.Bynweekday))
+	}
+	months := 12.0
+	if len(r.Bymonth) != 0 {
+		months = float64(len(r.Bymonth))
+	}
+	var perPeriod float64
+	switch r.Freq {
+	case YEARLY:
+		perPeriod = months * monthdays
+		if len(r.Bymonth) == 0 && len(r.Bynweekday) != 0 {
+			// Without BYMONTH, nth weekdays are relative to the year.
+			perPeriod = float64(len(r.Bynweekday))
+		}
+	case MONTHLY:
+		perPeriod = months / 12 * monthdays
+	default:
+		// Fraction of the days matching the filters.
+		perPeriod = months / 12 * monthdays / (365.2425 / 12)
+		if len(r.Byweekday) != 0 && r.Freq == WEEKLY {
+			perPeriod = months / 12 * float64(len(r.Byweekday))
+		}
+	}
+	if r.Freq < HOURLY {
+		perPeriod *= float64(len(r.Timeset))
+	} else {
+		for _, filter := range []struct {
+			values []int
+			total  int
+			freq   Frequency
+		}{{r.Byhour, 24, HOURLY}, {r.Byminute, 60, MINUTELY}, {r.Bysecond, 60, SECONDLY}} {
+			if len(filter.values) != 0 && r.Freq >= filter.freq {
+				perPeriod *= float64(len(filter.values)) / float64(filter.total)
+			}
+		}
+	}
+	if len(r.Bysetpos) != 0 && float64(len(r.Bysetpos)) < perPeriod {
+		perPeriod = float64(len(r.Bysetpos))
+	}
+	return perPeriod
+}
+
+// MaxDuration is returned by ApproximateDuration for rules without COUNT
+// and UNTIL.
+const MaxDuration = time.Duration(math.MaxInt64)
+
+// ApproximateDuration returns an approximation of the time span from DTSTART
+// to the last occurrence of the RRule, computed without generating the
+// occurrences where possible. It returns MaxDuration for infinite rules.
+func (r *RRule) ApproximateDuration() time.Duration {
+	if r.Count == 0 && r.OrigOptions.Until.IsZero() {
+		return MaxDuration
+	}
+	var d time.Duration
+	if r.Count != 0 {
+		if len(r.Byeaster) != 0 || len(r.Byweekno) != 0 || len(r.Byyearday) != 0 {
+			occurrences := r.All()
+			if len(occurrences) == 0 {
+				return 0
+			}
+			return occurrences[len(occurrences)-1].Sub(r.DateStart)
+		}
+		periods := float64(r.Count-1) / r.perPeriod()
+		d = time.Duration(periods * float64(r.Interval) * float64(averagePeriod(r.Freq)))
+	}
+	if !r.OrigOptions.Until.IsZero() {
+		if until := r.UntilTime.Sub(r.DateStart); r.Count == 0 || until < d {
+			d = until
+		}
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// Reduce calls fn with the running accumulator, starting with initial, and
+// each occurrence of the RRule in turn, and returns the final accumulator.
+// Occurrences are not materialized. See ReduceT for a type-safe version.
+func (r *RRule) Reduce(initial interface{}, fn func(acc interface{}, t time.Time) interface{}) interface{} {
+	return reduce(r.Iterator(), initial, fn)
+}
+
+// Rebase returns a copy of the RRule starting at newDTStart. UNTIL, if any,
+// is shifted by the same amount as DTSTART while COUNT and the BYXXX rule
+// parts are kept as they are.
+func (r *RRule) Rebase(newDTStart time.Time) (*RRule, error) {
+	option := r.OrigOptions.clone()
+	if !option.Until.IsZero() {
+		option.Until = option.Until.Add(newDTStart.Sub(r.DateStart))
+		if option.Until.Before(newDTStart) {
+			return nil, errors.New("UNTIL would be before DTSTART")
+		}
+	}
+	option.Dtstart = newDTStart
+	return NewRRule(option)
+}
+
+// CountTo returns the number of occurrences of the RRule up to and including
+// until, without collecting them. The count of DAILY and WEEKLY rules
+// without BYXXX rule parts is computed arithmetically.
+func (r *RRule) CountTo(until time.Time) int {
+	if n, ok := r.countToFast(until); ok {
+		return n
+	}
+	return betweenCount(r.Iterator(), r.DateStart, until, true)
+}
+
+// countToFast computes CountTo arithmetically, and reports whether it could.
+func (r *RRule) countToFast(until time.Time) (int, bool) {
+	option := r.OrigOptions
+	if r.Freq != DAILY && r.Freq != WEEKLY ||
+		len(option.Bysetpos) != 0 || len(option.Bymonth) != 0 || len(option.Bymonthday) != 0 ||
+		len(option.Byyearday) != 0 || len(option.Byweekno) != 0 || len(option.Byweekday) != 0 ||
+		len(option.Byhour) != 0 || len(option.Byminute) != 0 || len(option.Bysecond) != 0 ||
+		len(option.Byeaster) != 0 {
+		return 0, false
+	}
+	if r.UntilTime.Before(until) {
+		until = r.UntilTime
+	}
+	if until.Before(r.DateStart) {
+		return 0, true
+	}
+	if until.In(r.DateStart.Location()).Year() > MAXYEAR {
+		return 0, false
+	}
+	step := r.Interval
+	if r.Freq == WEEKLY {
+		step *= 7
+	}
+	n := daysBetween(r.DateStart, until.In(r.DateStart.Location())) / step
+	if r.DateStart.AddDate(0, 0, n*step).After(until) {
+		n--
+	}
+	count := n + 1
+	if r.Count != 0 && r.Count < count {
+		count = r.Count
+	}
+	return count, true
+}
+
+// EffectiveByweekday returns the weekdays the RRule recurs on, including
+// the DTSTART weekday implied for WEEKLY rules without BYXXX rule parts.
+// It returns nil if occurrences are not constrained by weekday.
+func (r *RRule) EffectiveByweekday() []Weekday {
+	var result []Weekday
+	for _, wday := range r.Byweekday {
+		result = append(result, weekdays[wday])
+	}
+	return append(result, r.Bynweekday...)
+}
+
+// EffectiveBymonth returns the months the RRule recurs in, including the
+// DTSTART month implied for YEARLY rules without BYXXX rule parts.
+func (r *RRule) EffectiveBymonth() []int {
+	return copyInts(r.Bymonth)
+}
+
+// EffectiveBymonthday returns the days of the month the RRule recurs on,
+// including the DTSTART day implied for YEARLY and MONTHLY rules without
+// BYXXX rule parts.
+func (r *RRule) EffectiveBymonthday() []int {
+	if len(r.Bymonthday) == 0 && len(r.Bynmonthday) == 0 {
+		return nil
+	}
+	return concat(r.Bymonthday, r.Bynmonthday)
+}
+
+// EffectiveByhour returns the hours the RRule recurs at, including the
+// DTSTART hour implied for rules less frequent than HOURLY.
+func (r *RRule) EffectiveByhour() []int {
+	return copyInts(r.Byhour)
+}
+
+// EffectiveByminute returns the minutes the RRule recurs at, including the
+// DTSTART minute implied for rules less frequent than MINUTELY.
+func (r *RRule) EffectiveByminute() []int {
+	return copyInts(r.Byminute)
+}
+
+// EffectiveBysecond returns the seconds the RRule recurs at, including the
+// DTSTART second implied for rules less frequent than SECONDLY.
+func (r *RRule) EffectiveBysecond() []int {
+	return copyInts(r.Bysecond)
+}
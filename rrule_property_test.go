@@ -0,0 +1,81 @@
+package rrule
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// randomOption generates valid ROptions for property based tests.
+type randomOption ROption
+
+func (randomOption) Generate(rand *rand.Rand, size int) reflect.Value {
+	pick := func(n, lo, hi int) []int {
+		var result []int
+		for i := rand.Intn(n + 1); i > 0; i-- {
+			result = append(result, lo+rand.Intn(hi-lo+1))
+		}
+		return result
+	}
+	option := ROption{
+		Freq:     Frequency(rand.Intn(int(SECONDLY) + 1)),
+		Interval: 1 + rand.Intn(5),
+		Wkst:     weekdays[rand.Intn(7)],
+		Dtstart: time.Date(1990+rand.Intn(30), time.Month(1+rand.Intn(12)), 1+rand.Intn(28),
+			rand.Intn(24), rand.Intn(60), rand.Intn(60), 0, time.UTC),
+		Bymonth: pick(2, 1, 12),
+	}
+	if rand.Intn(2) == 0 {
+		option.Count = 1 + rand.Intn(100)
+	} else {
+		option.Until = option.Dtstart.AddDate(1+rand.Intn(5), 0, 0)
+	}
+	for i := rand.Intn(3); i > 0; i-- {
+		wday := weekdays[rand.Intn(7)]
+		if option.Freq <= MONTHLY && rand.Intn(2) == 0 {
+			wday = wday.Nth(1 + rand.Intn(4))
+		}
+		option.Byweekday = append(option.Byweekday, wday)
+	}
+	if option.Freq >= HOURLY {
+		// Keep sub-daily rules from generating too sparse results.
+		option.Bymonth = nil
+	} else {
+		option.Byhour = pick(2, 0, 23)
+	}
+	return reflect.ValueOf(randomOption(option))
+}
+
+func TestRoundTripProperty(t *testing.T) {
+	property := func(ro randomOption) bool {
+		r, err := NewRRule(ROption(ro))
+		if err != nil {
+			t.Logf("NewRRule(%+v) failed: %v", ro, err)
+			return false
+		}
+		parsed, err := StrToRRule(r.String())
+		if err != nil {
+			t.Logf("StrToRRule(%q) failed: %v", r.String(), err)
+			return false
+		}
+		return timesEqual(firstOccurrences(r, 50), firstOccurrences(parsed, 50))
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Error(err)
+	}
+}
+
+func firstOccurrences(r *RRule, n int) []time.Time {
+	result := []time.Time{}
+	next := r.Iterator()
+	for i := 0; i < n; i++ {
+		v, ok := next()
+		if !ok {
+			break
+		}
+		result = append(result, v)
+	}
+	return result
+}
@@ -1,6 +1,11 @@
 package rrule
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -183,6 +188,16 @@ func TestInvalidRRules(t *testing.T) {
 			rrule:   ROption{Freq: YEARLY, Bysetpos: []int{-367}},
 			wantErr: "Bysetpos must be between 1 and 366 or -1 and -366",
 		},
+		{
+			desc:    "Byeaster under",
+			rrule:   ROption{Freq: YEARLY, Byeaster: []int{-367}},
+			wantErr: "Byeaster must be between -366 and 366",
+		},
+		{
+			desc:    "Byeaster over",
+			rrule:   ROption{Freq: YEARLY, Byeaster: []int{367}},
+			wantErr: "Byeaster must be between -366 and 366",
+		},
 		{
 			desc:    "Byday under",
 			rrule:   ROption{Freq: YEARLY, Byweekday: []Weekday{{1, -54}}},
@@ -3905,3 +3920,1175 @@ func TestRuleChangeDTStartTimezoneRespected(t *testing.T) {
 		}
 	}
 }
+
+func TestGaps(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: DAILY, Byhour: []int{9, 18},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	value := r.Gaps(time.Date(1997, 9, 2, 0, 0, 0, 0, time.UTC), time.Date(1997, 9, 3, 23, 0, 0, 0, time.UTC))
+	want := Gaps{9 * time.Hour, 15 * time.Hour, 9 * time.Hour}
+	if len(value) != len(want) {
+		t.Fatalf("get %v, want %v", value, want)
+	}
+	for i := range value {
+		if value[i] != want[i] {
+			t.Errorf("get %v, want %v", value, want)
+		}
+	}
+	if value.MaxGap() != 15*time.Hour {
+		t.Errorf("get %v, want %v", value.MaxGap(), 15*time.Hour)
+	}
+	if value.MinGap() != 9*time.Hour {
+		t.Errorf("get %v, want %v", value.MinGap(), 9*time.Hour)
+	}
+	if value.AverageGap() != 11*time.Hour {
+		t.Errorf("get %v, want %v", value.AverageGap(), 11*time.Hour)
+	}
+}
+
+func TestIsRegular(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Interval: 2,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	if !r.IsRegular() {
+		t.Errorf("get false, want true")
+	}
+	r, _ = NewRRule(ROption{Freq: DAILY, Byhour: []int{9, 18},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	if r.IsRegular() {
+		t.Errorf("get true, want false")
+	}
+}
+
+func TestWindow(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 5,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	day := 24 * time.Hour
+	value := r.Window(2*day, 2*day)
+	if len(value) != 3 || len(value[0]) != 2 || len(value[1]) != 2 || len(value[2]) != 1 {
+		t.Errorf("get %v, want windows of 2, 2 and 1 occurrences", value)
+	}
+	value = r.Window(3*day, day)
+	if len(value) != 5 || len(value[0]) != 3 || len(value[3]) != 2 || len(value[4]) != 1 {
+		t.Errorf("get %v, want overlapping windows", value)
+	}
+	value = r.WindowBetween(time.Date(1997, 9, 3, 0, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 5, 0, 0, 0, 0, time.UTC), day, day)
+	want := []time.Time{time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC)}
+	if len(value) != 3 || !timesEqual(value[1], want) {
+		t.Errorf("get %v, want second window %v", value, want)
+	}
+}
+
+func TestAllSafe(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: YEARLY,
+		Count:      3,
+		Bymonth:    []int{2},
+		Bymonthday: []int{31},
+		Dtstart:    time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	value, err := r.AllSafe()
+	if err != ErrMaxYearExceeded || len(value) != 0 {
+		t.Errorf("get %v, %v, want [], %v", value, err, ErrMaxYearExceeded)
+	}
+
+	r, _ = NewRRule(ROption{Freq: YEARLY, Count: 3,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	value, err = r.AllSafe()
+	if err != nil || len(value) != 3 {
+		t.Errorf("get %v, %v, want 3 occurrences and no error", value, err)
+	}
+
+	r, _ = NewRRule(ROption{Freq: YEARLY,
+		Dtstart: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)})
+	value, err = r.AllSafe()
+	if err != ErrMaxYearExceeded || len(value) != 293 {
+		t.Errorf("get %d occurrences, %v, want 293 occurrences, %v", len(value), err, ErrMaxYearExceeded)
+	}
+	r.DTStart(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	if value, err = r.AllSafe(); err != ErrMaxYearExceeded || len(value) != 293 {
+		t.Errorf("get %d occurrences, %v, want 293 occurrences, %v", len(value), err, ErrMaxYearExceeded)
+	}
+
+	r, _ = NewRRule(ROption{Freq: YEARLY,
+		Dtstart: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Until:   time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if value, err = r.AllSafe(); err != nil || len(value) != 11 {
+		t.Errorf("get %d occurrences, %v, want 11 occurrences and no error", len(value), err)
+	}
+}
+
+func TestMaterialize(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 3, Dtstart: dtstart})
+	cases := []struct {
+		limit     int
+		length    int
+		truncated bool
+	}{{0, 0, true}, {2, 2, true}, {3, 3, false}, {5, 3, false}}
+	for _, c := range cases {
+		value, truncated := r.Materialize(c.limit)
+		if len(value) != c.length || truncated != c.truncated {
+			t.Errorf("Materialize(%d) = %v, %v, want %d occurrences, %v", c.limit, value, truncated, c.length, c.truncated)
+		}
+	}
+
+	r, _ = NewRRule(ROption{Freq: DAILY, Dtstart: dtstart})
+	value, truncated := r.Materialize(100)
+	if len(value) != 100 || !truncated || cap(value) != 100 {
+		t.Errorf("get %d occurrences, %v, want 100 occurrences, true", len(value), truncated)
+	}
+}
+
+func TestActiveDuring(t *testing.T) {
+	rules := []ROption{
+		{Freq: YEARLY, Interval: 2, Bymonth: []int{2}, Bymonthday: []int{29}},
+		{Freq: MONTHLY, Interval: 5, Byweekday: []Weekday{FR.Nth(-1)}},
+		{Freq: WEEKLY, Interval: 3, Byweekday: []Weekday{MO, SA}, Wkst: SU},
+		{Freq: DAILY, Interval: 11},
+		{Freq: HOURLY, Interval: 7, Until: time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Freq: DAILY, Count: 30},
+	}
+	for _, option := range rules {
+		option.Dtstart = time.Date(1997, 9, 3, 9, 0, 0, 0, time.UTC)
+		r, _ := NewRRule(option)
+		for _, start := range []time.Time{time.Date(1997, 9, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(1997, 10, 6, 9, 0, 0, 0, time.UTC), time.Date(1998, 3, 27, 12, 0, 0, 0, time.UTC),
+			time.Date(2016, 2, 29, 9, 0, 0, 0, time.UTC), time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)} {
+			for _, d := range []time.Duration{0, time.Hour, 24 * time.Hour, 10 * 24 * time.Hour} {
+				end := start.Add(d)
+				want := len(r.Between(start, end, true)) != 0
+				if value := r.ActiveDuring(start, end); value != want {
+					t.Errorf("%v: ActiveDuring(%v, %v) = %v, want %v", r, start, end, value, want)
+				}
+			}
+		}
+	}
+}
+
+func TestByEasterOutOfYear(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: YEARLY, Count: 2, Byeaster: []int{366, 0},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	want := []time.Time{time.Date(1998, 4, 12, 9, 0, 0, 0, time.UTC),
+		time.Date(1999, 4, 4, 9, 0, 0, 0, time.UTC)}
+	value := r.All()
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	for _, option := range []ROption{
+		{Freq: WEEKLY, Interval: 2, Byweekday: []Weekday{MO, FR}, Until: time.Date(1998, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Freq: MONTHLY, Count: 10, Byweekday: []Weekday{FR.Nth(-1)}},
+	} {
+		option.Dtstart = time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+		r, _ := NewRRule(option)
+		all := r.All()
+		before, after, err := r.Split(all[4])
+		if err != nil {
+			t.Fatal("expected", nil, "got", err)
+		}
+		value := append(before.All(), after.All()...)
+		if !timesEqual(value, all) {
+			t.Errorf("get %v, want %v", value, all)
+		}
+		if value := after.All()[0]; value != all[4] {
+			t.Errorf("get %v, want %v", value, all[4])
+		}
+	}
+
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 3,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	if _, _, err := r.Split(time.Date(1997, 9, 3, 10, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("get nil, want error for a time which is not an occurrence")
+	}
+	if _, _, err := r.Split(time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("get nil, want error for the first occurrence")
+	}
+}
+
+func TestAllOnDay(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{TU, TH}, Byhour: []int{9, 18},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	want := []time.Time{time.Date(2020, 3, 5, 9, 0, 0, 0, time.UTC),
+		time.Date(2020, 3, 5, 18, 0, 0, 0, time.UTC)}
+	value := r.AllOnDay(2020, time.March, 5)
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value := r.AllOnDay(2020, time.March, 6); len(value) != 0 {
+		t.Errorf("get %v, want none", value)
+	}
+
+	r, _ = NewRRule(ROption{Freq: HOURLY, Interval: 8,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	want = []time.Time{time.Date(1997, 9, 3, 1, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 3, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 3, 17, 0, 0, 0, time.UTC)}
+	value = r.AllOnDay(1997, time.September, 3)
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+}
+
+func TestAllInWeek(t *testing.T) {
+	// January 1st 2020 is a Wednesday.
+	r, _ := NewRRule(ROption{Freq: DAILY, Interval: 2,
+		Dtstart: time.Date(2019, 12, 1, 9, 0, 0, 0, time.UTC)})
+	want := []time.Time{time.Date(2019, 12, 31, 9, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 2, 9, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 4, 9, 0, 0, 0, time.UTC)}
+	value := r.AllInWeek(2020, 1)
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	r, _ = NewRRule(ROption{Freq: DAILY, Interval: 2, Wkst: SU,
+		Dtstart: time.Date(2019, 12, 1, 9, 0, 0, 0, time.UTC)})
+	want = []time.Time{time.Date(2019, 12, 29, 9, 0, 0, 0, time.UTC),
+		time.Date(2019, 12, 31, 9, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 2, 9, 0, 0, 0, time.UTC),
+		time.Date(2020, 1, 4, 9, 0, 0, 0, time.UTC)}
+	value = r.AllInWeek(2020, 1)
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+}
+
+func TestBetweenCount(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: HOURLY, Interval: 5, Byweekday: []Weekday{MO, WE},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	after, before := time.Date(1997, 9, 3, 4, 0, 0, 0, time.UTC), time.Date(1997, 12, 3, 9, 0, 0, 0, time.UTC)
+	for _, inc := range []bool{true, false} {
+		want := len(r.Between(after, before, inc))
+		if value := r.BetweenCount(after, before, inc); value != want {
+			t.Errorf("get %v, want %v", value, want)
+		}
+	}
+}
+
+func TestNthOccurrence(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 10,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	from := time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC)
+	value, ok := r.NthOccurrenceAfter(3, from)
+	if want := time.Date(1997, 9, 7, 9, 0, 0, 0, time.UTC); !ok || value != want {
+		t.Errorf("get %v, %v, want %v", value, ok, want)
+	}
+	if _, ok := r.NthOccurrenceAfter(8, from); ok {
+		t.Error("get true, want false")
+	}
+	value, ok = r.NthOccurrenceBefore(2, from)
+	if want := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC); !ok || value != want {
+		t.Errorf("get %v, %v, want %v", value, ok, want)
+	}
+	if _, ok := r.NthOccurrenceBefore(3, from); ok {
+		t.Error("get true, want false")
+	}
+}
+
+func TestWithBy(t *testing.T) {
+	base, _ := NewRRule(ROption{Freq: MONTHLY, Count: 2,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	r, err := base.WithBymonthday(1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err = r.WithByhour(8); err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{time.Date(1997, 9, 30, 8, 0, 0, 0, time.UTC),
+		time.Date(1997, 10, 1, 8, 0, 0, 0, time.UTC)}
+	if value := r.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	want = []time.Time{time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 10, 2, 9, 0, 0, 0, time.UTC)}
+	if value := base.All(); !timesEqual(value, want) {
+		t.Errorf("base rule was modified: get %v, want %v", value, want)
+	}
+	if _, err := base.WithBymonth(13); err == nil {
+		t.Error("get nil, want error")
+	}
+	if r, err := base.WithByweekday(FR.Nth(-1)); err != nil || r.All()[0] != time.Date(1997, 9, 26, 9, 0, 0, 0, time.UTC) {
+		t.Errorf("get %v, %v, want last friday", r, err)
+	}
+}
+
+func TestEstimateCount(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	before := time.Date(2001, 3, 5, 0, 0, 0, 0, time.UTC)
+	for _, option := range []ROption{
+		{Freq: DAILY},
+		{Freq: MINUTELY, Interval: 30},
+		{Freq: WEEKLY, Byweekday: []Weekday{MO, WE, FR}},
+		{Freq: MONTHLY, Bymonthday: []int{1, 15}},
+		{Freq: YEARLY, Bymonth: []int{1, 2}, Byweekday: []Weekday{MO}},
+		{Freq: HOURLY, Byhour: []int{9, 18}},
+	} {
+		option.Dtstart = dtstart
+		r, _ := NewRRule(option)
+		want := len(r.Between(dtstart, before, false)) + 1
+		value := r.EstimateCount(before)
+		if value < 0 || float64(value) > 1.2*float64(want) || float64(value) < 0.8*float64(want) {
+			t.Errorf("%v: get %v, want about %v", r, value, want)
+		}
+	}
+
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 10, Dtstart: dtstart})
+	if value := r.EstimateCount(before); value != 10 {
+		t.Errorf("get %v, want %v", value, 10)
+	}
+	if value := r.EstimateCount(dtstart.AddDate(-1, 0, 0)); value != 0 {
+		t.Errorf("get %v, want %v", value, 0)
+	}
+	r, _ = NewRRule(ROption{Freq: YEARLY, Byeaster: []int{0}, Dtstart: dtstart})
+	if value := r.EstimateCount(before); value != -1 {
+		t.Errorf("get %v, want %v", value, -1)
+	}
+}
+
+func TestApproximateDuration(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	cases := []struct {
+		option ROption
+		want   time.Duration
+	}{
+		{ROption{Freq: DAILY, Count: 10}, 9 * 24 * time.Hour},
+		{ROption{Freq: WEEKLY, Interval: 2, Count: 3}, 28 * 24 * time.Hour},
+		{ROption{Freq: DAILY, Until: dtstart.AddDate(0, 0, 30)}, 30 * 24 * time.Hour},
+		{ROption{Freq: DAILY, Count: 100, Until: dtstart.AddDate(0, 0, 30)}, 30 * 24 * time.Hour},
+		{ROption{Freq: YEARLY, Count: 2, Byeaster: []int{0}},
+			time.Date(1999, 4, 4, 9, 0, 0, 0, time.UTC).Sub(dtstart)},
+		{ROption{Freq: DAILY}, MaxDuration},
+	}
+	for _, c := range cases {
+		c.option.Dtstart = dtstart
+		r, _ := NewRRule(c.option)
+		if value := r.ApproximateDuration(); value != c.want {
+			t.Errorf("%v: get %v, want %v", &c.option, value, c.want)
+		}
+	}
+
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Count: 30, Byweekday: []Weekday{MO, WE, FR}, Dtstart: dtstart})
+	all := r.All()
+	want := all[len(all)-1].Sub(dtstart)
+	if value := r.ApproximateDuration(); value < want-7*24*time.Hour || value > want+7*24*time.Hour {
+		t.Errorf("get %v, want about %v", value, want)
+	}
+}
+
+func TestConcurrentIterators(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 100,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	want := r.All()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value := r.All(); !timesEqual(value, want) {
+				t.Errorf("get %v, want %v", value, want)
+			}
+		}()
+	}
+	wg.Wait()
+	if r.Total() != 100 || r.Len != 0 {
+		t.Errorf("get Total %d and Len %d, want 100 and 0", r.Total(), r.Len)
+	}
+}
+
+func TestReduce(t *testing.T) {
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 10,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	count := r.Reduce(0, func(acc interface{}, t time.Time) interface{} {
+		return acc.(int) + 1
+	})
+	if count != 10 {
+		t.Errorf("get %v, want 10", count)
+	}
+
+	empty, _ := NewRRule(ROption{Freq: DAILY, Until: time.Date(1997, 1, 1, 0, 0, 0, 0, time.UTC),
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	if value := empty.Reduce("initial", nil); value != "initial" {
+		t.Errorf("get %v, want initial", value)
+	}
+}
+
+func TestAllAfterBefore(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 5, Dtstart: dtstart})
+	all := r.All()
+	cases := []struct {
+		value, want []time.Time
+	}{
+		{r.AllAfter(all[2], true), all[2:]},
+		{r.AllAfter(all[2], false), all[3:]},
+		{r.AllAfter(dtstart.AddDate(1, 0, 0), true), []time.Time{}},
+		{r.AllBefore(all[2], true), all[:3]},
+		{r.AllBefore(all[2], false), all[:2]},
+		{r.AllBefore(dtstart, false), []time.Time{}},
+	}
+	for i, c := range cases {
+		if !timesEqual(c.value, c.want) {
+			t.Errorf("%d: get %v, want %v", i, c.value, c.want)
+		}
+	}
+
+	weekly, _ := NewRRule(ROption{Freq: WEEKLY, Dtstart: dtstart,
+		Until: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)})
+	from := time.Date(1999, 12, 1, 0, 0, 0, 0, time.UTC)
+	if value, want := weekly.AllAfter(from, true), weekly.Between(from, weekly.UntilTime, true); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+}
+
+func TestFrequencyInterval(t *testing.T) {
+	cases := []struct {
+		option ROption
+		want   time.Duration
+	}{
+		{ROption{Freq: YEARLY}, 365 * 24 * time.Hour},
+		{ROption{Freq: MONTHLY, Interval: 2}, 60 * 24 * time.Hour},
+		{ROption{Freq: WEEKLY, Interval: 3}, 21 * 24 * time.Hour},
+		{ROption{Freq: DAILY}, 24 * time.Hour},
+		{ROption{Freq: HOURLY, Interval: 6}, 6 * time.Hour},
+		{ROption{Freq: MINUTELY, Interval: 15}, 15 * time.Minute},
+		{ROption{Freq: SECONDLY, Interval: 30}, 30 * time.Second},
+	}
+	for _, c := range cases {
+		r, _ := NewRRule(c.option)
+		if value := r.FrequencyInterval(); value != c.want {
+			t.Errorf("%v: get %v, want %v", r, value, c.want)
+		}
+	}
+}
+
+func TestMonthlyLargeIntervalMaxYear(t *testing.T) {
+	r, err := StrToRRule("FREQ=MONTHLY;INTERVAL=200;DTSTART=98000101T000000Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := r.AllSafe()
+	if err != ErrMaxYearExceeded || len(value) != 12 {
+		t.Fatalf("get %d occurrences, %v, want 12 occurrences, %v", len(value), err, ErrMaxYearExceeded)
+	}
+	if last := value[len(value)-1]; last.Year() > MAXYEAR || !last.Equal(time.Date(9983, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("get %v, want %v", last, time.Date(9983, 5, 1, 0, 0, 0, 0, time.UTC))
+	}
+}
+
+func TestAllBetweenWithContext(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Dtstart: dtstart})
+	after, before := dtstart.AddDate(0, 1, 0), dtstart.AddDate(0, 2, 0)
+	value, err := r.AllBetweenWithContext(context.Background(), after, before, true)
+	if want := r.Between(after, before, true); err != nil || !timesEqual(value, want) {
+		t.Errorf("get %v, %v, want %v, nil", value, err, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	value, err = r.AllBetweenWithContext(ctx, after, before, true)
+	if err != context.Canceled || len(value) != 0 {
+		t.Errorf("get %v, %v, want [], %v", value, err, context.Canceled)
+	}
+
+	// Cancel after a few occurrences to get partial results.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	next := r.Iterator()
+	n := 0
+	value = between(contextIterator(ctx, func() (time.Time, bool) {
+		if n++; n == 3 {
+			cancel()
+		}
+		return next()
+	}, &err), dtstart, before, true)
+	if err != context.Canceled || len(value) != 3 {
+		t.Errorf("get %v, %v, want 3 occurrences, %v", value, err, context.Canceled)
+	}
+}
+
+func TestExcludeWeekends(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC) // Monday
+	r, err := NewRRule(ROption{Freq: DAILY, Count: 6, ExcludeWeekends: true, Dtstart: dtstart})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range r.All() {
+		if v.Weekday() == time.Saturday || v.Weekday() == time.Sunday {
+			t.Errorf("get %v, want no weekend", v)
+		}
+	}
+	if want := "FREQ=DAILY;DTSTART=20180101T090000Z;COUNT=6;BYDAY=FR,MO,TH,TU,WE"; r.String() != want {
+		t.Errorf("get %v, want %v", r, want)
+	}
+
+	r, _ = NewRRule(ROption{Freq: WEEKLY, Count: 4, ExcludeWeekdays: true, Dtstart: dtstart})
+	want := []time.Time{dtstart.AddDate(0, 0, 5), dtstart.AddDate(0, 0, 6), dtstart.AddDate(0, 0, 12), dtstart.AddDate(0, 0, 13)}
+	if value := r.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	// MONTHLY and YEARLY rules keep the day, and month, of DTSTART.
+	dtstart = time.Date(2020, 1, 15, 9, 0, 0, 0, time.UTC) // Wednesday
+	r, _ = NewRRule(ROption{Freq: MONTHLY, Count: 5, ExcludeWeekends: true, Dtstart: dtstart})
+	want = []time.Time{dtstart, dtstart.AddDate(0, 3, 0), dtstart.AddDate(0, 4, 0),
+		dtstart.AddDate(0, 5, 0), dtstart.AddDate(0, 6, 0)}
+	if value := r.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if want := "FREQ=MONTHLY;DTSTART=20200115T090000Z;COUNT=5;BYMONTHDAY=15;BYDAY=FR,MO,TH,TU,WE"; r.String() != want {
+		t.Errorf("get %v, want %v", r, want)
+	}
+	r, _ = NewRRule(ROption{Freq: YEARLY, Count: 3, ExcludeWeekends: true, Dtstart: dtstart})
+	want = []time.Time{dtstart, dtstart.AddDate(1, 0, 0), dtstart.AddDate(4, 0, 0)}
+	if value := r.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	r, _ = NewRRule(ROption{Freq: YEARLY, Count: 2, ExcludeWeekdays: true, Bymonth: []int{2, 3}, Dtstart: dtstart})
+	want = []time.Time{dtstart.AddDate(0, 1, 0), dtstart.AddDate(0, 2, 0)}
+	if value := r.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	dtstart = time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ = NewRRule(ROption{Freq: MONTHLY, ExcludeWeekends: true, Byweekday: []Weekday{MO.Nth(1), SA, FR}, Dtstart: dtstart})
+	if want := "FREQ=MONTHLY;DTSTART=20180101T090000Z;BYDAY=FR,+1MO"; r.String() != want {
+		t.Errorf("get %v, want %v", r, want)
+	}
+
+	for _, option := range []ROption{
+		{Freq: DAILY, ExcludeWeekends: true, ExcludeWeekdays: true},
+		{Freq: DAILY, ExcludeWeekdays: true, Byweekday: []Weekday{MO}},
+	} {
+		if _, err := NewRRule(option); err == nil {
+			t.Errorf("get nil error for %v, want error", &option)
+		}
+	}
+}
+
+func TestRebase(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO, TH}, Dtstart: dtstart,
+		Until: dtstart.AddDate(0, 1, 0)})
+	rebased, err := r.Rebase(dtstart.AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "FREQ=WEEKLY;DTSTART=20190101T090000Z;UNTIL=20190201T090000Z;BYDAY=MO,TH"; rebased.String() != want {
+		t.Errorf("get %v, want %v", rebased, want)
+	}
+	if r.DateStart != dtstart {
+		t.Errorf("Rebase modified the rule: %v", r)
+	}
+
+	r, _ = NewRRule(ROption{Freq: DAILY, Count: 3, Dtstart: dtstart})
+	rebased, _ = r.Rebase(dtstart.AddDate(0, 0, 10))
+	if value := rebased.All(); len(value) != 3 || !value[0].Equal(dtstart.AddDate(0, 0, 10)) {
+		t.Errorf("get %v, want 3 occurrences from %v", value, dtstart.AddDate(0, 0, 10))
+	}
+
+	r, _ = NewRRule(ROption{Freq: DAILY, Dtstart: dtstart, Until: dtstart.AddDate(0, 0, -1)})
+	if _, err := r.Rebase(dtstart.AddDate(0, 0, 10)); err == nil {
+		t.Error("get nil error, want error")
+	}
+}
+
+func TestCountTo(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, ny)
+	until := time.Date(2018, 12, 31, 9, 0, 0, 0, ny)
+	options := []ROption{
+		{Freq: DAILY},
+		{Freq: DAILY, Interval: 3},
+		{Freq: DAILY, Count: 20},
+		{Freq: DAILY, Until: time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{Freq: WEEKLY, Interval: 2},
+		{Freq: WEEKLY, Byweekday: []Weekday{MO, FR}},
+		{Freq: MONTHLY, Bymonthday: []int{31}},
+		{Freq: HOURLY, Interval: 5},
+	}
+	for _, option := range options {
+		option.Dtstart = dtstart
+		r, _ := NewRRule(option)
+		for _, u := range []time.Time{until, until.Add(-time.Second), dtstart, dtstart.Add(-time.Second)} {
+			if value, want := r.CountTo(u), len(r.Between(dtstart, u, true)); value != want {
+				t.Errorf("%v: CountTo(%v) = %d, want %d", r, u, value, want)
+			}
+		}
+	}
+}
+
+func TestCalendarMasks(t *testing.T) {
+	for _, year := range []int{1997, 2000, 2018} {
+		yearlen := 365 + isLeap(year)
+		wdaymask, wdaymaskSU := WeekdayMask(year, MO), WeekdayMask(year, SU)
+		mmask, mdaymask := MonthMask(year), MonthDayMask(year)
+		for _, mask := range [][]int{wdaymask, wdaymaskSU, mmask, mdaymask} {
+			if len(mask) != yearlen+7 {
+				t.Fatalf("get %d days, want %d", len(mask), yearlen+7)
+			}
+		}
+		for i := 0; i < yearlen+7; i++ {
+			day := time.Date(year, 1, 1+i, 0, 0, 0, 0, time.UTC)
+			if wdaymask[i] != toPyWeekday(day.Weekday()) || wdaymaskSU[i] != int(day.Weekday()) ||
+				mmask[i] != int(day.Month()) || mdaymask[i] != day.Day() {
+				t.Fatalf("%v: get %d, %d, %d, %d", day, wdaymask[i], wdaymaskSU[i], mmask[i], mdaymask[i])
+			}
+		}
+	}
+	MonthMask(2000)[0] = 0
+	if M366MASK[0] != 1 {
+		t.Error("MonthMask returned the shared mask")
+	}
+}
+
+func TestEffectiveByxxx(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 30, 15, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Dtstart: dtstart})
+	if value, want := r.EffectiveByweekday(), []Weekday{TU}; !reflect.DeepEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value, want := r.EffectiveByhour(), []int{9}; !reflect.DeepEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value, want := r.EffectiveByminute(), []int{30}; !reflect.DeepEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value, want := r.EffectiveBysecond(), []int{15}; !reflect.DeepEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value := r.EffectiveBymonthday(); value != nil {
+		t.Errorf("get %v, want nil", value)
+	}
+
+	r, _ = NewRRule(ROption{Freq: YEARLY, Dtstart: dtstart})
+	if value, want := r.EffectiveBymonth(), []int{9}; !reflect.DeepEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value, want := r.EffectiveBymonthday(), []int{2}; !reflect.DeepEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	r, _ = NewRRule(ROption{Freq: MONTHLY, Dtstart: dtstart,
+		Byweekday: []Weekday{MO, FR.Nth(-1)}, Bymonthday: []int{1, -1}})
+	if value, want := r.EffectiveByweekday(), []Weekday{MO, FR.Nth(-1)}; !reflect.DeepEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value, want := r.EffectiveBymonthday(), []int{1, -1}; !reflect.DeepEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	r, _ = NewRRule(ROption{Freq: HOURLY, Dtstart: dtstart})
+	if r.EffectiveByweekday() != nil || r.EffectiveByhour() != nil {
+		t.Errorf("get %v, %v, want no constraint", r.EffectiveByweekday(), r.EffectiveByhour())
+	}
+}
+
+func TestTimeZone(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 2, Dtstart: time.Date(2018, 1, 1, 9, 0, 0, 0, loc)})
+	if r.TimeZone() != loc {
+		t.Errorf("get %v, want %v", r.TimeZone(), loc)
+	}
+	for _, v := range r.All() {
+		if v.Location() != r.TimeZone() {
+			t.Errorf("%v not in %v", v, r.TimeZone())
+		}
+	}
+	r.DTStart(time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC))
+	if r.TimeZone() != time.UTC {
+		t.Errorf("get %v, want UTC", r.TimeZone())
+	}
+}
+
+func TestWithTimezone(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	london, _ := time.LoadLocation("Europe/London")
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO},
+		Dtstart: time.Date(2018, 3, 5, 9, 0, 0, 0, ny),
+		Until:   time.Date(2018, 4, 2, 9, 0, 0, 0, ny).UTC()})
+	converted, err := r.WithTimezone(london)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted.TimeZone() != london {
+		t.Errorf("get %v, want %v", converted.TimeZone(), london)
+	}
+	value := converted.All()
+	if len(value) != 5 {
+		t.Fatalf("get %v, want 5 occurrences", value)
+	}
+	for _, v := range value {
+		if h, m, _ := v.Clock(); h != 9 || m != 0 || v.Location() != london {
+			t.Errorf("get %v, want 09:00 in London", v)
+		}
+	}
+	if r.TimeZone() != ny {
+		t.Error("WithTimezone modified the rule")
+	}
+	if _, err := r.WithTimezone(nil); err == nil {
+		t.Error("get nil, want error")
+	}
+}
+
+func TestBetweenIncludesDTStart(t *testing.T) {
+	for _, dtstart := range []time.Time{
+		time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC),
+		time.Date(2018, 1, 1, 9, 0, 0, 500000000, time.UTC),
+	} {
+		r, _ := NewRRule(ROption{Freq: DAILY, Dtstart: dtstart})
+		before := r.OrigOptions.Dtstart.AddDate(0, 0, 5)
+		inc, exc := r.Between(r.OrigOptions.Dtstart, before, true), r.Between(r.OrigOptions.Dtstart, before, false)
+		if len(inc) != len(exc)+2 || inc[0] != r.DateStart {
+			t.Errorf("%v: get %v and %v, want DTSTART and the before bound included", dtstart, inc, exc)
+		}
+	}
+}
+
+func TestGenerateN(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Dtstart: dtstart})
+	value, err := r.GenerateN(dtstart.AddDate(0, 0, 7), 52)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(value) != 52 || value[0] != dtstart.AddDate(0, 0, 7) || value[51] != dtstart.AddDate(0, 0, 52*7) {
+		t.Errorf("get %d occurrences from %v to %v", len(value), value[0], value[len(value)-1])
+	}
+
+	r, _ = NewRRule(ROption{Freq: DAILY, Count: 5, Dtstart: dtstart})
+	value, err = r.GenerateN(dtstart.Add(time.Hour), 10)
+	if !errors.Is(err, ErrInsufficientOccurrences) || len(value) != 4 {
+		t.Errorf("get %v and %v, want 4 occurrences and ErrInsufficientOccurrences", value, err)
+	}
+	if err != nil && err.Error() != "insufficient occurrences: got 4 of 10" {
+		t.Errorf("get %q", err)
+	}
+}
+
+func TestAllBetweenPage(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Dtstart: dtstart})
+	after, before := dtstart.AddDate(0, 0, 2), dtstart.AddDate(0, 0, 11)
+	all := r.Between(after, before, true)
+	for _, c := range []struct{ offset, limit, want int }{
+		{0, 4, 4}, {4, 4, 4}, {8, 4, 2}, {10, 4, 0}, {20, 4, 0}, {-1, 3, 3}, {0, 0, 0},
+	} {
+		page, total := r.AllBetweenPage(after, before, true, c.offset, c.limit)
+		if total != len(all) || len(page) != c.want {
+			t.Errorf("%+v: get %v and total %d, want %d occurrences and total %d", c, page, total, c.want, len(all))
+			continue
+		}
+		offset := c.offset
+		if offset < 0 {
+			offset = 0
+		}
+		if c.want != 0 && !timesEqual(page, all[offset:offset+c.want]) {
+			t.Errorf("%+v: get %v, want %v", c, page, all[offset:offset+c.want])
+		}
+	}
+	if page, total := r.AllBetweenPage(after, before, false, 0, 100); total != len(all)-2 || len(page) != total {
+		t.Errorf("get %v and total %d, want %d", page, total, len(all)-2)
+	}
+}
+
+func TestContainsAll(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO, WE}, Dtstart: dtstart})
+	times := []time.Time{dtstart.AddDate(0, 0, 9), dtstart, dtstart.AddDate(0, 0, 2), dtstart.AddDate(0, 0, 2)}
+	if !r.ContainsAll(times) || !r.ContainsAny(times) {
+		t.Errorf("%v: get false, want true", times)
+	}
+	if !r.ContainsAll(nil) || r.ContainsAny(nil) {
+		t.Error("get wrong result for no times")
+	}
+	times = append(times, dtstart.AddDate(0, 0, 1))
+	if r.ContainsAll(times) || !r.ContainsAny(times) {
+		t.Errorf("%v: get wrong result", times)
+	}
+	times = []time.Time{dtstart.Add(time.Hour), dtstart.AddDate(0, 0, 3), dtstart.AddDate(0, 0, -7)}
+	if r.ContainsAll(times) || r.ContainsAny(times) {
+		t.Errorf("%v: get true, want false", times)
+	}
+	if !r.ContainsAny([]time.Time{dtstart.AddDate(0, 0, 1), dtstart.AddDate(0, 0, 7).In(time.FixedZone("UTC+8", 8*3600))}) {
+		t.Error("get false for an occurrence in another time zone, want true")
+	}
+}
+
+func TestHash(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r1, _ := NewRRule(ROption{Freq: WEEKLY, Interval: 1, Byweekday: []Weekday{MO, FR}, Dtstart: dtstart})
+	r2, _ := NewRRuleFromString("FREQ=WEEKLY;DTSTART=20180101T090000Z;BYDAY=FR,MO")
+	if r1.Hash() != r2.Hash() {
+		t.Errorf("get %x and %x for %v and %v, want equal hashes", r1.Hash(), r2.Hash(), r1, r2)
+	}
+	// The hash does not depend on how the rule is written.
+	r3, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO, FR}, Dtstart: dtstart,
+		WeekdayNames: []string{"LU", "MA", "ME", "JE", "VE", "SA", "DI"}})
+	if r3.Hash() != r1.Hash() {
+		t.Errorf("get %x and %x for %v and %v, want equal hashes", r1.Hash(), r3.Hash(), r1, r3)
+	}
+	// Rules differing only in the RFC flag have different hashes.
+	r3, _ = NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO, FR}, Dtstart: dtstart, RFC: true})
+	if r3.Hash() == r1.Hash() {
+		t.Errorf("get equal hashes for %v and %v with RFC set", r1, r3)
+	}
+	// Defaulted parts are filled in from DTSTART.
+	r1, _ = NewRRule(ROption{Freq: MONTHLY, Dtstart: dtstart})
+	r2, _ = NewRRule(ROption{Freq: MONTHLY, Bymonthday: []int{1}, Byhour: []int{9}, Dtstart: dtstart})
+	if r1.Hash() != r2.Hash() {
+		t.Errorf("get %x and %x for %v and %v, want equal hashes", r1.Hash(), r2.Hash(), r1, r2)
+	}
+
+	ny, _ := time.LoadLocation("America/New_York")
+	for _, option := range []ROption{
+		{Freq: WEEKLY, Byweekday: []Weekday{MO, TU}, Dtstart: dtstart},
+		{Freq: MONTHLY, Dtstart: dtstart.AddDate(0, 0, 1)},
+		{Freq: MONTHLY, Dtstart: dtstart.In(ny)},
+		{Freq: MONTHLY, Count: 3, Dtstart: dtstart},
+		{Freq: MONTHLY, Until: dtstart.AddDate(1, 0, 0), Dtstart: dtstart},
+		{Freq: MONTHLY, Dtstart: dtstart, GenerateInclusive: true},
+	} {
+		r, _ := NewRRule(option)
+		if r.Hash() == r1.Hash() {
+			t.Errorf("get equal hashes for %v and %v", r, r1)
+		}
+	}
+}
+
+func TestFirstN(t *testing.T) {
+	// 2018-01-01 is a Monday.
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{WE, FR}, Dtstart: dtstart})
+	value := r.FirstN(3)
+	want := []time.Time{dtstart.AddDate(0, 0, 2), dtstart.AddDate(0, 0, 4), dtstart.AddDate(0, 0, 9)}
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if first := r.FirstN(1); len(first) != 1 || first[0] != r.After(dtstart, true) {
+		t.Errorf("get %v, want the first occurrence %v", first, r.After(dtstart, true))
+	}
+	r, _ = NewRRule(ROption{Freq: DAILY, Count: 2, Dtstart: dtstart})
+	if value := r.FirstN(5); len(value) != 2 {
+		t.Errorf("get %v, want 2 occurrences", value)
+	}
+	if value := r.FirstN(0); len(value) != 0 {
+		t.Errorf("get %v, want no occurrence", value)
+	}
+}
+
+func TestAllWeekdays(t *testing.T) {
+	// 2018-01-01 is a Monday.
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO, TH}, Byhour: []int{9, 17}, Dtstart: dtstart})
+	value := r.AllWeekdays(dtstart, dtstart.AddDate(0, 0, 14))
+	if len(value) != 2 || len(value[time.Monday]) != 5 || len(value[time.Thursday]) != 4 {
+		t.Errorf("get %v", value)
+	}
+	for weekday, times := range value {
+		for _, v := range times {
+			if v.Weekday() != weekday {
+				t.Errorf("get %v for %v", v, weekday)
+			}
+		}
+	}
+	if value := r.AllWeekdays(dtstart.AddDate(0, 0, 1), dtstart.AddDate(0, 0, 2)); len(value) != 0 {
+		t.Errorf("get %v, want no weekday", value)
+	}
+}
+
+func TestNextBusinessDay(t *testing.T) {
+	// 2018-01-05 is a Friday.
+	dtstart := time.Date(2018, 1, 5, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 6, Dtstart: dtstart})
+	if value, want := r.NextBusinessDay(dtstart), dtstart.AddDate(0, 0, 3); value != want {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value, want := r.NextBusinessDay(dtstart.Add(-time.Second)), dtstart; value != want {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	want := []time.Time{dtstart.AddDate(0, 0, 3), dtstart.AddDate(0, 0, 4), dtstart.AddDate(0, 0, 5)}
+	if value := r.NextNBusinessDays(dtstart, 5); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value := r.NextBusinessDay(dtstart.AddDate(0, 0, 5)); !value.IsZero() {
+		t.Errorf("get %v, want zero time", value)
+	}
+}
+
+func TestIsEquivalentTo(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	daily, _ := NewRRule(ROption{Freq: DAILY, Interval: 7, Dtstart: dtstart})
+	weekly, _ := NewRRule(ROption{Freq: WEEKLY, Dtstart: dtstart, RFC: true})
+	if !daily.IsEquivalentTo(weekly, 0) || !weekly.IsEquivalentTo(daily, 0) {
+		t.Errorf("%v and %v: get false, want true", daily, weekly)
+	}
+	later, _ := NewRRule(ROption{Freq: WEEKLY, Dtstart: dtstart.AddDate(0, 0, 7)})
+	if daily.IsEquivalentTo(later, 0) {
+		t.Errorf("%v and %v: get true, want false", daily, later)
+	}
+	monday, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO}, Count: 3, Dtstart: dtstart})
+	if !monday.IsEquivalentTo(weekly, 14*24*time.Hour) || monday.IsEquivalentTo(weekly, 0) {
+		t.Errorf("%v and %v: get wrong result", monday, weekly)
+	}
+	secondly, _ := NewRRule(ROption{Freq: SECONDLY, Dtstart: dtstart})
+	minutely, _ := NewRRule(ROption{Freq: MINUTELY, Bysecond: rang(0, 60), Dtstart: dtstart})
+	if !secondly.IsEquivalentTo(minutely, 0) {
+		t.Errorf("%v and %v: get false, want true", secondly, minutely)
+	}
+}
+
+func TestFactories(t *testing.T) {
+	// 2018-01-31 is a Wednesday.
+	dtstart := time.Date(2018, 1, 31, 9, 0, 0, 0, time.UTC)
+	r, err := Daily(dtstart, 1)
+	if err != nil || r.String() != "FREQ=DAILY;DTSTART=20180131T090000Z" {
+		t.Errorf("get %v and %v", r, err)
+	}
+	r, err = Weekly(dtstart, 2, MO, FR)
+	if err != nil || r.String() != "FREQ=WEEKLY;DTSTART=20180131T090000Z;INTERVAL=2;BYDAY=FR,MO" {
+		t.Errorf("get %v and %v", r, err)
+	}
+	if want := dtstart.AddDate(0, 0, 2); r.After(dtstart, true) != want {
+		t.Errorf("get %v, want %v", r.After(dtstart, true), want)
+	}
+	r, _ = Weekly(dtstart, 1)
+	if value := r.FirstN(2); value[1] != dtstart.AddDate(0, 0, 7) {
+		t.Errorf("get %v", value)
+	}
+	r, err = Monthly(dtstart, 1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, want := r.FirstN(2)[1], time.Date(2018, 2, 28, 9, 0, 0, 0, time.UTC); value != want {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	r, _ = Yearly(dtstart, 4)
+	if value, want := r.FirstN(2)[1], dtstart.AddDate(4, 0, 0); value != want {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	for _, f := range []func() (*RRule, error){
+		func() (*RRule, error) { return Daily(dtstart, 0) },
+		func() (*RRule, error) { return Weekly(dtstart, -1, MO) },
+		func() (*RRule, error) { return Monthly(dtstart, 1, 32) },
+		func() (*RRule, error) { return Monthly(dtstart, 1, 0) },
+		func() (*RRule, error) { return Yearly(dtstart, 0) },
+	} {
+		if r, err := f(); err == nil {
+			t.Errorf("get %v, want error", r)
+		}
+	}
+}
+
+func TestTimesetRebuilt(t *testing.T) {
+	timeset := func(r *RRule) []string {
+		var result []string
+		for _, v := range r.Timeset {
+			result = append(result, v.Format("15:04:05"))
+		}
+		return result
+	}
+	r, _ := NewRRule(ROption{Freq: DAILY, Byhour: []int{17, 9},
+		Dtstart: time.Date(2018, 1, 1, 9, 30, 15, 0, time.UTC)})
+	if value, want := timeset(r), []string{"09:30:15", "17:30:15"}; !reflect.DeepEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	with := func(r *RRule, err error) *RRule {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	if value, want := timeset(with(r.WithByhour(12))), []string{"12:30:15"}; !reflect.DeepEqual(value, want) {
+		t.Errorf("WithByhour: get %v, want %v", value, want)
+	}
+	if value, want := timeset(with(r.WithByminute(0, 45))), []string{"09:00:15", "09:45:15", "17:00:15", "17:45:15"}; !reflect.DeepEqual(value, want) {
+		t.Errorf("WithByminute: get %v, want %v", value, want)
+	}
+	if value, want := timeset(with(r.WithBysecond(0))), []string{"09:30:00", "17:30:00"}; !reflect.DeepEqual(value, want) {
+		t.Errorf("WithBysecond: get %v, want %v", value, want)
+	}
+	r.DTStart(time.Date(2018, 1, 1, 8, 5, 0, 0, time.UTC))
+	if value, want := timeset(r), []string{"09:05:00", "17:05:00"}; !reflect.DeepEqual(value, want) {
+		t.Errorf("DTStart: get %v, want %v", value, want)
+	}
+	if value := r.FirstN(2); value[0] != time.Date(2018, 1, 1, 9, 5, 0, 0, time.UTC) {
+		t.Errorf("get %v", value)
+	}
+}
+
+func TestAllFuture(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	r, _ := NewRRule(ROption{Freq: DAILY, Dtstart: now.AddDate(0, 0, -10)})
+	future := r.AllFuture(3)
+	if len(future) != 3 || !future[0].After(now) || future[0].After(now.AddDate(0, 0, 1)) {
+		t.Errorf("get %v, want the next 3 occurrences after %v", future, now)
+	}
+	past := r.AllPast(3)
+	if len(past) != 3 || !past[0].Before(past[2]) || !past[2].Before(now.Add(time.Second)) || past[2].Before(now.AddDate(0, 0, -1)) {
+		t.Errorf("get %v, want the last 3 occurrences before %v", past, now)
+	}
+	if !r.IsCurrentlyRecurring() {
+		t.Error("get false, want true")
+	}
+
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ = NewRRule(ROption{Freq: WEEKLY, Count: 3, Dtstart: dtstart})
+	if value := r.lastN(dtstart.AddDate(1, 0, 0), 5); !timesEqual(value, r.All()) {
+		t.Errorf("get %v, want %v", value, r.All())
+	}
+	if value := r.nextN(dtstart, 5); !timesEqual(value, r.All()[1:]) {
+		t.Errorf("get %v, want %v", value, r.All()[1:])
+	}
+	for _, c := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{dtstart.Add(-time.Second), false}, {dtstart, true}, {dtstart.AddDate(0, 0, 10), true},
+		{dtstart.AddDate(0, 0, 14), true}, {dtstart.AddDate(0, 0, 14).Add(time.Second), false},
+	} {
+		if value := r.recurringAt(c.t); value != c.want {
+			t.Errorf("recurringAt(%v) = %v, want %v", c.t, value, c.want)
+		}
+	}
+	if r.IsCurrentlyRecurring() || len(r.AllFuture(1)) != 0 || len(r.AllPast(5)) != 3 {
+		t.Error("get wrong result for a finished rule")
+	}
+}
+
+func TestAllExcept(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 10, Dtstart: dtstart})
+	weekly, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{SA, SU}, Dtstart: dtstart})
+	third, _ := NewRRule(ROption{Freq: DAILY, Interval: 3, Count: 2, Dtstart: dtstart})
+	exclusions := []*RRule{weekly, third}
+
+	set := &Set{}
+	set.RRule(r)
+	set.ExRule(weekly)
+	set.ExRule(third)
+	want := set.All()
+	if value := r.AllExcept(exclusions); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value := r.AllExcept(nil); !timesEqual(value, r.All()) {
+		t.Errorf("get %v, want %v", value, r.All())
+	}
+
+	after, before := dtstart.AddDate(0, 0, 1), dtstart.AddDate(0, 0, 8)
+	want = set.Between(after, before, true)
+	if value := r.AllExceptBetween(exclusions, after, before, true); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	want = set.Between(after, before, false)
+	if value := r.AllExceptBetween(exclusions, after, before, false); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if len(r.All()) != 10 || len(weekly.FirstN(20)) != 20 {
+		t.Error("rules were modified")
+	}
+}
+
+func TestSliceFrom(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Interval: 2, Dtstart: dtstart})
+	from := time.Date(2118, 1, 1, 9, 0, 0, 0, time.UTC)
+	want := []time.Time{from, from.AddDate(0, 0, 2), from.AddDate(0, 0, 4)}
+	if value := r.SliceFrom(from, 3); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value := r.SliceFrom(from.Add(time.Second), 1); !timesEqual(value, want[1:2]) {
+		t.Errorf("get %v, want %v", value, want[1:2])
+	}
+	if value := r.SliceFrom(from, 0); len(value) != 0 {
+		t.Errorf("get %v, want none", value)
+	}
+	r, _ = NewRRule(ROption{Freq: DAILY, Count: 3, Dtstart: dtstart})
+	if value := r.SliceFrom(dtstart.AddDate(0, 0, 1), 5); !timesEqual(value, r.All()[1:]) {
+		t.Errorf("get %v, want %v", value, r.All()[1:])
+	}
+}
+
+func BenchmarkSliceFrom(b *testing.B) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Dtstart: dtstart})
+	for _, years := range []int{1, 10, 100, 1000} {
+		from := dtstart.AddDate(years, 0, 0)
+		b.Run(fmt.Sprintf("DAILY+%dy", years), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				r.SliceFrom(from, 10)
+			}
+		})
+	}
+}
+
+func TestGenerateInclusive(t *testing.T) {
+	dtstart := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: MONTHLY, Count: 3, Byweekday: []Weekday{FR}, Dtstart: dtstart, GenerateInclusive: true})
+	want := []time.Time{dtstart, time.Date(2020, 1, 3, 9, 0, 0, 0, time.UTC), time.Date(2020, 1, 10, 9, 0, 0, 0, time.UTC)}
+	if value := r.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value := r.Total(); value != 3 {
+		t.Errorf("get %v, want 3", value)
+	}
+	if value := r.String(); value != "FREQ=MONTHLY;DTSTART=20200101T090000Z;COUNT=3;BYDAY=FR" {
+		t.Errorf("get %v", value)
+	}
+
+	r, _ = NewRRule(ROption{Freq: MONTHLY, Count: 1, Byweekday: []Weekday{FR}, Dtstart: dtstart, GenerateInclusive: true})
+	if value := r.All(); !timesEqual(value, want[:1]) {
+		t.Errorf("get %v, want %v", value, want[:1])
+	}
+
+	// DTSTART matching the rule is not generated twice.
+	r, _ = NewRRule(ROption{Freq: DAILY, Count: 2, Dtstart: dtstart, GenerateInclusive: true})
+	want = []time.Time{dtstart, dtstart.AddDate(0, 0, 1)}
+	if value := r.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	r, _ = NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{FR}, Dtstart: dtstart, GenerateInclusive: true})
+	want = []time.Time{dtstart, time.Date(2020, 1, 3, 9, 0, 0, 0, time.UTC)}
+	if value := r.Between(dtstart, want[1], true); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value := r.After(dtstart.AddDate(1, 0, 0), false); value.Weekday() != time.Friday {
+		t.Errorf("get %v, want a Friday", value)
+	}
+}
+
+func TestAllInTimezone(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	// Every 6 hours in UTC, so that occurrences fall on both sides of the
+	// month bounds in New York, which moves from EDT to EST in November.
+	r, _ := NewRRule(ROption{Freq: HOURLY, Interval: 6, Dtstart: time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)})
+	for _, month := range []time.Month{time.October, time.November, time.December} {
+		value := r.AllInTimezone(ny, 2018, month)
+		var want []time.Time
+		for _, v := range r.All() {
+			if v.In(ny).Month() == month && v.In(ny).Year() == 2018 {
+				want = append(want, v)
+			}
+		}
+		if !timesEqual(value, want) {
+			t.Errorf("%v: get %v, want %v", month, value, want)
+		}
+	}
+	value := r.AllInTimezone(ny, 2018, time.November)
+	if want := time.Date(2018, 11, 1, 6, 0, 0, 0, time.UTC); !value[0].Equal(want) {
+		t.Errorf("get %v, want %v", value[0], want)
+	}
+	if want := time.Date(2018, 12, 1, 0, 0, 0, 0, time.UTC); !value[len(value)-1].Equal(want) {
+		t.Errorf("get %v, want %v", value[len(value)-1], want)
+	}
+	if value := r.AllInTimezone(ny, 2018, time.October); !value[0].Equal(time.Date(2018, 10, 1, 6, 0, 0, 0, time.UTC)) {
+		t.Errorf("get %v", value[0])
+	}
+	if value := r.AllInTimezone(time.UTC, 2018, time.October); len(value) != 31*4 {
+		t.Errorf("get %v occurrences, want %v", len(value), 31*4)
+	}
+}
@@ -0,0 +1,156 @@
+// Package rruleprom instruments rrule-go recurrences with Prometheus metrics.
+//
+// It lives in its own module so that the core rrule package stays free of
+// third-party dependencies.
+//
+// The following metrics are registered:
+//
+//	rrule_occurrences_generated_total      counter, occurrences produced by any call
+//	rrule_calls_total{method}              counter, calls of All/Between/Before/After
+//	rrule_all_duration_seconds             histogram, wall time of All calls
+//	rrule_all_result_size                  histogram, number of occurrences returned by All
+package rruleprom
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/teambition/rrule-go"
+)
+
+// Recurrence is the query interface shared by *rrule.RRule and *rrule.Set.
+type Recurrence interface {
+	All() []time.Time
+	Between(after, before time.Time, inc bool) []time.Time
+	Before(dt time.Time, inc bool) time.Time
+	After(dt time.Time, inc bool) time.Time
+}
+
+var (
+	_ Recurrence = (*rrule.RRule)(nil)
+	_ Recurrence = (*rrule.Set)(nil)
+)
+
+// Metrics holds the collectors shared by instrumented recurrences.
+type Metrics struct {
+	occurrences prometheus.Counter
+	calls       *prometheus.CounterVec
+	allDuration prometheus.Histogram
+	allSize     prometheus.Histogram
+}
+
+// NewMetrics creates the collectors and registers them with reg. If reg
+// already has them, from a previous call, they are reused, so that all the
+// recurrences instrumented with reg share the same collectors.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		occurrences: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rrule_occurrences_generated_total",
+			Help: "Total number of occurrences returned by instrumented recurrences.",
+		}),
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rrule_calls_total",
+			Help: "Total number of queries made on instrumented recurrences.",
+		}, []string{"method"}),
+		allDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "rrule_all_duration_seconds",
+			Help:    "Time spent generating all occurrences of a recurrence.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		allSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "rrule_all_result_size",
+			Help:    "Number of occurrences returned by All.",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+		}),
+	}
+	var err error
+	if m.occurrences, err = register(reg, m.occurrences); err != nil {
+		return nil, err
+	}
+	if m.calls, err = register(reg, m.calls); err != nil {
+		return nil, err
+	}
+	if m.allDuration, err = register(reg, m.allDuration); err != nil {
+		return nil, err
+	}
+	if m.allSize, err = register(reg, m.allSize); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// register registers c with reg, or returns the collector reg already has
+// with the same descriptors.
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) (C, error) {
+	err := reg.Register(c)
+	var are prometheus.AlreadyRegisteredError
+	if !errors.As(err, &are) {
+		return c, err
+	}
+	existing, ok := are.ExistingCollector.(C)
+	if !ok {
+		return c, fmt.Errorf("%w with a collector of type %T", err, are.ExistingCollector)
+	}
+	return existing, nil
+}
+
+// Instrumented wraps a Recurrence and records metrics for every query.
+type Instrumented struct {
+	r Recurrence
+	m *Metrics
+}
+
+// NewInstrumentedRRule wraps r, recording metrics into collectors registered with reg.
+// Rules instrumented with the same reg share its collectors, see NewMetrics.
+func NewInstrumentedRRule(r *rrule.RRule, reg prometheus.Registerer) (*Instrumented, error) {
+	m, err := NewMetrics(reg)
+	if err != nil {
+		return nil, err
+	}
+	return m.Wrap(r), nil
+}
+
+// Wrap returns r instrumented with m. The same Metrics may wrap many recurrences.
+func (m *Metrics) Wrap(r Recurrence) *Instrumented {
+	return &Instrumented{r: r, m: m}
+}
+
+// All returns all occurrences of the wrapped recurrence.
+func (i *Instrumented) All() []time.Time {
+	i.m.calls.WithLabelValues("all").Inc()
+	start := time.Now()
+	result := i.r.All()
+	i.m.allDuration.Observe(time.Since(start).Seconds())
+	i.m.allSize.Observe(float64(len(result)))
+	i.m.occurrences.Add(float64(len(result)))
+	return result
+}
+
+// Between returns the occurrences of the wrapped recurrence between after and before.
+func (i *Instrumented) Between(after, before time.Time, inc bool) []time.Time {
+	i.m.calls.WithLabelValues("between").Inc()
+	result := i.r.Between(after, before, inc)
+	i.m.occurrences.Add(float64(len(result)))
+	return result
+}
+
+// Before returns the last occurrence of the wrapped recurrence before dt.
+func (i *Instrumented) Before(dt time.Time, inc bool) time.Time {
+	i.m.calls.WithLabelValues("before").Inc()
+	return i.observeOne(i.r.Before(dt, inc))
+}
+
+// After returns the first occurrence of the wrapped recurrence after dt.
+func (i *Instrumented) After(dt time.Time, inc bool) time.Time {
+	i.m.calls.WithLabelValues("after").Inc()
+	return i.observeOne(i.r.After(dt, inc))
+}
+
+func (i *Instrumented) observeOne(t time.Time) time.Time {
+	if !t.IsZero() {
+		i.m.occurrences.Inc()
+	}
+	return t
+}
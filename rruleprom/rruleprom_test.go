@@ -0,0 +1,47 @@
+package rruleprom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/teambition/rrule-go"
+)
+
+func TestInstrumentedRRule(t *testing.T) {
+	r, _ := rrule.NewRRule(rrule.ROption{Freq: rrule.DAILY, Count: 5,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	reg := prometheus.NewRegistry()
+	ir, err := NewInstrumentedRRule(r, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ir.All()); n != 5 {
+		t.Errorf("get %v, want %v", n, 5)
+	}
+	ir.After(time.Date(1997, 9, 3, 0, 0, 0, 0, time.UTC), false)
+	if v := testutil.ToFloat64(ir.m.occurrences); v != 6 {
+		t.Errorf("get %v, want %v", v, 6)
+	}
+	if v := testutil.ToFloat64(ir.m.calls.WithLabelValues("after")); v != 1 {
+		t.Errorf("get %v, want %v", v, 1)
+	}
+
+	// A second rule on the same registry shares the collectors.
+	r, _ = rrule.NewRRule(rrule.ROption{Freq: rrule.WEEKLY, Count: 3,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	ir2, err := NewInstrumentedRRule(r, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ir2.All()); n != 3 {
+		t.Errorf("get %v, want %v", n, 3)
+	}
+	if v := testutil.ToFloat64(ir.m.occurrences); v != 9 {
+		t.Errorf("get %v, want %v", v, 9)
+	}
+	if n := testutil.CollectAndCount(reg, "rrule_calls_total"); n != 2 {
+		t.Errorf("get %v series, want %v", n, 2)
+	}
+}
@@ -1,43 +1,182 @@
 package rrule
 
 import (
+	"container/heap"
+	"errors"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 )
 
-// Set allows more complex recurrence setups, mixing multiple rules, dates, exclusion rules, and exclusion dates
+// Set allows more complex recurrence setups, mixing multiple rules, dates, exclusion rules, and exclusion dates.
+// The zero value is an empty set; NewRRuleSet is the recommended way to construct one.
 type Set struct {
 	rrule   []*RRule
 	rdate   []time.Time
 	exrule  []*RRule
 	exdate  []time.Time
 	dtstart time.Time
+	// exrange holds blackout ranges added by AddExcludeRange.
+	exrange []timeRange
+
+	// skip and limit are set by Skip and Truncate, limit is only
+	// applied when truncated is true.
+	skip      int
+	limit     int
+	truncated bool
+
+	// sources holds the recurrence a set built by MaterializeBetween was
+	// materialized from, if requested.
+	sources []string
+
+	// merged holds the sets combined by Merge when they can not be
+	// flattened into one. Their occurrences are generated along with the
+	// ones of the rules and dates of the set.
+	merged []*Set
+}
+
+// NewRRuleSet constructs a new Set with the given DTSTART and rules.
+// The DTSTART is propagated to every rule. An error is returned if dtstart is
+// zero, if any rule is nil or if a rule was built with a DTSTART in a
+// different time zone than dtstart.
+func NewRRuleSet(dtstart time.Time, rules ...*RRule) (*Set, error) {
+	if dtstart.IsZero() {
+		return nil, errors.New("dtstart is required")
+	}
+	for i, r := range rules {
+		if r == nil {
+			return nil, fmt.Errorf("rule %d is nil", i)
+		}
+		orig := r.OrigOptions.Dtstart
+		if !orig.IsZero() && orig.Location().String() != dtstart.Location().String() {
+			return nil, fmt.Errorf("rule %d has DTSTART in %v, want %v", i, orig.Location(), dtstart.Location())
+		}
+	}
+	set := &Set{}
+	set.DTStart(dtstart)
+	for _, r := range rules {
+		set.RRule(r)
+	}
+	return set, nil
 }
 
 // Recurrence returns a slice of all the recurrence rules for a set
 func (set *Set) Recurrence() []string {
 	var res []string
-
 	if !set.dtstart.IsZero() {
 		// No colon, DTSTART may have TZID, which would require a semicolon after DTSTART
 		res = append(res, fmt.Sprintf("DTSTART%s", timeToDtStartStr(set.dtstart)))
 	}
 	for _, item := range set.rrule {
-		res = append(res, fmt.Sprintf("RRULE:%s", item))
-	}
-	for _, item := range set.rdate {
-		res = append(res, fmt.Sprintf("RDATE:%s", timeToStr(item)))
+		if option, ok := set.countRule(); ok {
+			res = append(res, fmt.Sprintf("RRULE:%s", &option))
+			continue
+		}
+		res = append(res, fmt.Sprintf("RRULE:%s", &item.OrigOptions))
 	}
+	res = append(res, dateLines("RDATE", set.rdate)...)
 	for _, item := range set.exrule {
-		res = append(res, fmt.Sprintf("EXRULE:%s", item))
+		res = append(res, fmt.Sprintf("EXRULE:%s", &item.OrigOptions))
 	}
-	for _, item := range set.exdate {
-		res = append(res, fmt.Sprintf("EXDATE:%s", timeToStr(item)))
+	if set.exdatesAreDates() {
+		dates := make([]string, len(set.exdate))
+		for i, item := range set.exdate {
+			dates[i] = item.UTC().Format(DateFormat)
+		}
+		res = append(res, fmt.Sprintf("EXDATE;VALUE=DATE:%s", strings.Join(dates, ",")))
+	} else {
+		res = append(res, dateLines("EXDATE", set.exdate)...)
+	}
+	// Sets combined by Merge are written whole, each between BEGIN and END
+	// lines, so that their exclusions only apply to their own occurrences.
+	for _, m := range set.merged {
+		res = append(res, "BEGIN:"+mergedSetComponent)
+		res = append(res, m.Recurrence()...)
+		res = append(res, "END:"+mergedSetComponent)
+	}
+	for _, item := range set.sources {
+		res = append(res, fmt.Sprintf("X-RRULE-SOURCE:%s", item))
+	}
+	for _, item := range set.exrange {
+		res = append(res, fmt.Sprintf("X-RRULE-EXRANGE:%s", item))
+	}
+	// Skip and truncation COUNT can not express are written as extension
+	// properties, which NewSetFromString parses back.
+	if _, ok := set.countRule(); !ok {
+		if set.skip != 0 {
+			res = append(res, fmt.Sprintf("X-RRULE-SKIP:%d", set.skip))
+		}
+		if set.truncated {
+			res = append(res, fmt.Sprintf("X-RRULE-LIMIT:%d", set.limit))
+		}
 	}
 	return res
 }
 
+// mergedSetComponent is the name of the component holding a set combined by
+// Merge in the recurrence of the merged set.
+const mergedSetComponent = "X-RRULE-SET"
+
+// countRule returns the options of the only rule of a truncated set with
+// its COUNT set to the limit of the set, if the truncation can be expressed
+// that way.
+func (set *Set) countRule() (ROption, bool) {
+	if !set.truncated || set.limit <= 0 || set.skip != 0 || len(set.rrule) != 1 ||
+		len(set.rdate) != 0 || len(set.exrule) != 0 || len(set.exdate) != 0 ||
+		len(set.exrange) != 0 || len(set.merged) != 0 {
+		return ROption{}, false
+	}
+	option := set.rrule[0].OrigOptions
+	if option.Count == 0 || set.limit < option.Count {
+		option.Count = set.limit
+	}
+	return option, true
+}
+
+// dateLines returns the property name with times as values, in a single
+// line for times in UTC or in an unnamed time zone, which are written in
+// UTC, and in one line with a TZID per named time zone. Lines are in the
+// order of the first of their times.
+func dateLines(name string, times []time.Time) []string {
+	var zones []string
+	values := map[string][]string{}
+	for _, t := range times {
+		zone, value := "", timeToStr(t)
+		if hasNamedZone(t) {
+			zone, value = t.Location().String(), t.Format(LocalDateTimeFormat)
+		}
+		if _, ok := values[zone]; !ok {
+			zones = append(zones, zone)
+		}
+		values[zone] = append(values[zone], value)
+	}
+	res := make([]string, len(zones))
+	for i, zone := range zones {
+		params := ""
+		if zone != "" {
+			params = ";TZID=" + zone
+		}
+		res[i] = fmt.Sprintf("%s%s:%s", name, params, strings.Join(values[zone], ","))
+	}
+	return res
+}
+
+// exdatesAreDates reports whether the set has EXDATEs which are all at
+// midnight UTC, and can be written as dates. Dates are parsed in the time
+// zone of DTSTART, so the set must not have a DTSTART in another time zone.
+func (set *Set) exdatesAreDates() bool {
+	if len(set.exdate) == 0 || !set.dtstart.IsZero() && set.dtstart.Location().String() != "UTC" {
+		return false
+	}
+	for _, item := range set.exdate {
+		if !item.UTC().Truncate(24 * time.Hour).Equal(item) {
+			return false
+		}
+	}
+	return true
+}
+
 // DTStart sets DateStart property for all rules in set
 func (set *Set) DTStart(dtstart time.Time) {
 	set.dtstart = dtstart.Truncate(time.Second)
@@ -51,11 +190,36 @@ func (set *Set) DTStart(dtstart time.Time) {
 	}
 }
 
-// GetDTStart gets DateStart for set
+// GetDTStart gets DateStart for set.
+// It returns the zero time if no DTSTART was set, even if the rules in the
+// set have their own DTSTART; see InferDTStart.
 func (set *Set) GetDTStart() time.Time {
 	return set.dtstart
 }
 
+// HasDTStart reports whether a DTSTART was set for the set.
+func (set *Set) HasDTStart() bool {
+	return !set.dtstart.IsZero()
+}
+
+// InferDTStart returns the DTSTART of the set if it was set, or else the
+// earliest DTSTART of its rules. It returns the zero time if the set has
+// neither.
+func (set *Set) InferDTStart() time.Time {
+	if set.HasDTStart() {
+		return set.dtstart
+	}
+	var dtstart time.Time
+	for _, rules := range [][]*RRule{set.rrule, set.exrule} {
+		for _, r := range rules {
+			if dtstart.IsZero() || r.DateStart.Before(dtstart) {
+				dtstart = r.DateStart
+			}
+		}
+	}
+	return dtstart
+}
+
 // RRule include the given rrule instance in the recurrence set generation.
 func (set *Set) RRule(rrule *RRule) {
 	if !set.dtstart.IsZero() {
@@ -64,9 +228,11 @@ func (set *Set) RRule(rrule *RRule) {
 	set.rrule = append(set.rrule, rrule)
 }
 
-// GetRRule return the rrules in the set
+// GetRRule return the rrules in the set, in insertion order.
+// The returned slice is a copy, but its rules are shared with the set
+// and should not be mutated.
 func (set *Set) GetRRule() []*RRule {
-	return set.rrule
+	return append([]*RRule(nil), set.rrule...)
 }
 
 // RDate include the given datetime instance in the recurrence set generation.
@@ -74,14 +240,27 @@ func (set *Set) RDate(rdate time.Time) {
 	set.rdate = append(set.rdate, rdate)
 }
 
+// AddRDate includes the given datetime instance in the recurrence set generation
+// unless an equal one is already included, in which case ErrAlreadyExists is returned.
+// The rdates are kept sorted.
+func (set *Set) AddRDate(rdate time.Time) error {
+	return addTime(&set.rdate, rdate)
+}
+
+// RemoveRDate removes the given datetime instance from the rdates and reports
+// whether it was found.
+func (set *Set) RemoveRDate(rdate time.Time) bool {
+	return removeTime(&set.rdate, rdate)
+}
+
 // SetRDates sets explicitly added dates (rdates) in the set
 func (set *Set) SetRDates(rdates []time.Time) {
 	set.rdate = rdates
 }
 
-// GetRDate returns explicitly added dates (rdates) in the set
+// GetRDate returns a copy of explicitly added dates (rdates) in the set
 func (set *Set) GetRDate() []time.Time {
-	return set.rdate
+	return copyTimes(set.rdate)
 }
 
 // ExRule include the given rrule instance in the recurrence set exclusion list.
@@ -94,9 +273,11 @@ func (set *Set) ExRule(exrule *RRule) {
 	set.exrule = append(set.exrule, exrule)
 }
 
-// GetExRule returns exclusion rrules list from in the set
+// GetExRule returns exclusion rrules list from in the set, in insertion order.
+// The returned slice is a copy, but its rules are shared with the set
+// and should not be mutated.
 func (set *Set) GetExRule() []*RRule {
-	return set.exrule
+	return append([]*RRule(nil), set.exrule...)
 }
 
 // ExDate include the given datetime instance in the recurrence set exclusion list.
@@ -106,14 +287,61 @@ func (set *Set) ExDate(exdate time.Time) {
 	set.exdate = append(set.exdate, exdate)
 }
 
+// AddExcludeDate includes the given datetime instance in the exclusion list
+// unless an equal one is already included, in which case ErrAlreadyExists is returned.
+// The exdates are kept sorted.
+func (set *Set) AddExcludeDate(exdate time.Time) error {
+	return addTime(&set.exdate, exdate)
+}
+
+// RemoveExcludeDate removes the given datetime instance from the exclusion list
+// and reports whether it was found.
+func (set *Set) RemoveExcludeDate(exdate time.Time) bool {
+	return removeTime(&set.exdate, exdate)
+}
+
 // SetExDates sets explicitly excluded dates (exdates) in the set
 func (set *Set) SetExDates(exdates []time.Time) {
 	set.exdate = exdates
 }
 
-// GetExDate returns explicitly excluded dates (exdates) in the set
+// GetExDate returns a copy of explicitly excluded dates (exdates) in the set
 func (set *Set) GetExDate() []time.Time {
-	return set.exdate
+	return copyTimes(set.exdate)
+}
+
+// CollapseExRules replaces the EXRULEs of the set by EXDATEs of their
+// occurrences up to and including until, for clients which do not support
+// the deprecated EXRULE. If until is zero, all occurrences are used and an
+// error is returned if any EXRULE has neither COUNT nor UNTIL. Occurrences
+// after until are no longer excluded. The set is left unchanged on error.
+// Existing EXDATEs keep their order, and occurrences which are not already
+// EXDATEs are appended to them.
+func (set *Set) CollapseExRules(until time.Time) error {
+	if until.IsZero() {
+		for i, r := range set.exrule {
+			if r.OrigOptions.Count == 0 && r.OrigOptions.Until.IsZero() {
+				return fmt.Errorf("exrule %d is unbounded", i)
+			}
+		}
+	}
+	// exdates is a sorted copy used to skip duplicates.
+	exdates := uniqueTimes(set.exdate)
+	for _, r := range set.exrule {
+		var occurrences []time.Time
+		if until.IsZero() {
+			occurrences = r.All()
+		} else {
+			occurrences = r.AllBefore(until, true)
+		}
+		for _, t := range occurrences {
+			if addTime(&exdates, t) == nil {
+				set.exdate = append(set.exdate, t)
+			}
+		}
+	}
+	set.exrule = nil
+	return nil
 }
 
 type genItem struct {
@@ -127,6 +355,13 @@ func (s genItemSlice) Len() int           { return len(s) }
 func (s genItemSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s genItemSlice) Less(i, j int) bool { return s[i].dt.Before(s[j].dt) }
 
+func (s *genItemSlice) Push(x interface{}) { *s = append(*s, x.(genItem)) }
+func (s *genItemSlice) Pop() interface{} {
+	item := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return item
+}
+
 func addGenList(genList *[]genItem, next Next) {
 	dt, ok := next()
 	if ok {
@@ -134,8 +369,10 @@ func addGenList(genList *[]genItem, next Next) {
 	}
 }
 
-// Iterator returns an iterator for rrule.Set
-func (set *Set) Iterator() (next func() (time.Time, bool)) {
+// Iterator returns an iterator for rrule.Set. It merges the occurrences of
+// the RRULEs and RDATEs, minus those of the EXRULEs and EXDATEs, in sorted
+// order and without duplicates. Like RRule.Iterator, it returns a Next.
+func (set *Set) Iterator() (next Next) {
 	rlist := []genItem{}
 	exlist := []genItem{}
 
@@ -144,6 +381,9 @@ func (set *Set) Iterator() (next func() (time.Time, bool)) {
 	for _, r := range set.rrule {
 		addGenList(&rlist, r.Iterator())
 	}
+	for _, m := range set.merged {
+		addGenList(&rlist, m.Iterator())
+	}
 	sort.Sort(genItemSlice(rlist))
 
 	sort.Sort(timeSlice(set.exdate))
@@ -154,7 +394,7 @@ func (set *Set) Iterator() (next func() (time.Time, bool)) {
 	sort.Sort(genItemSlice(exlist))
 
 	lastdt := time.Time{}
-	return func() (time.Time, bool) {
+	next = func() (time.Time, bool) {
 		for len(rlist) != 0 {
 			dt := rlist[0].dt
 			var ok bool
@@ -179,13 +419,41 @@ func (set *Set) Iterator() (next func() (time.Time, bool)) {
 		}
 		return time.Time{}, false
 	}
+	if len(set.exrange) != 0 {
+		next = excludeRangesIterator(next, set.exrange)
+	}
+	if set.skip != 0 {
+		next = skipIterator(next, set.skip)
+	}
+	if set.truncated {
+		next = truncateIterator(next, set.limit)
+	}
+	return next
 }
 
 // All returns all occurrences of the rrule.Set.
+// An occurrence generated by several rules or dates is returned once.
 func (set *Set) All() []time.Time {
 	return all(set.Iterator())
 }
 
+// AllWithDeduplicate is same as All, but removes duplicated occurrences in
+// a dedicated pass instead of relying on the iterator. Occurrences are
+// duplicated if they denote the same instant, even in different locations.
+func (set *Set) AllWithDeduplicate() []time.Time {
+	result := all(set.Iterator())
+	if len(result) == 0 {
+		return result
+	}
+	deduplicated := result[:1]
+	for _, t := range result[1:] {
+		if !t.Equal(deduplicated[len(deduplicated)-1]) {
+			deduplicated = append(deduplicated, t)
+		}
+	}
+	return deduplicated
+}
+
 // Between returns all the occurrences of the rrule between after and before.
 // The inc keyword defines what happens if after and/or before are themselves occurrences.
 // With inc == True, they will be included in the list, if they are found in the recurrence set.
@@ -193,6 +461,18 @@ func (set *Set) Between(after, before time.Time, inc bool) []time.Time {
 	return between(set.Iterator(), after, before, inc)
 }
 
+// AllAfter returns all the occurrences of the set after the given time,
+// which is included if inc is true and it is an occurrence.
+func (set *Set) AllAfter(after time.Time, inc bool) []time.Time {
+	return allAfter(set.Iterator(), after, inc)
+}
+
+// AllBefore returns all the occurrences of the set before the given time,
+// which is included if inc is true and it is an occurrence.
+func (set *Set) AllBefore(before time.Time, inc bool) []time.Time {
+	return allBefore(set.Iterator(), before, inc)
+}
+
 // Before Returns the last recurrence before the given datetime instance,
 // or time.Time's zero value if no recurrence match.
 // The inc keyword defines what happens if dt is an occurrence.
@@ -205,6 +485,390 @@ func (set *Set) Before(dt time.Time, inc bool) time.Time {
 // or time.Time's zero value if no recurrence match.
 // The inc keyword defines what happens if dt is an occurrence.
 // With inc == True, if dt itself is an occurrence, it will be returned.
+// Times excluded by EXRULE or EXDATE are skipped.
 func (set *Set) After(dt time.Time, inc bool) time.Time {
 	return after(set.Iterator(), dt, inc)
 }
+
+// clone returns a deep copy of the set.
+func (set *Set) clone() *Set {
+	c := &Set{
+		rdate:   copyTimes(set.rdate),
+		exdate:  copyTimes(set.exdate),
+		dtstart: set.dtstart,
+		exrange: append([]timeRange(nil), set.exrange...),
+
+		skip:      set.skip,
+		limit:     set.limit,
+		truncated: set.truncated,
+
+		sources: append([]string(nil), set.sources...),
+	}
+	for _, r := range set.rrule {
+		c.rrule = append(c.rrule, r.clone())
+	}
+	for _, r := range set.exrule {
+		c.exrule = append(c.exrule, r.clone())
+	}
+	for _, m := range set.merged {
+		c.merged = append(c.merged, m.clone())
+	}
+	return c
+}
+
+// Merge returns a new set whose occurrences are the union of the ones of
+// set and other, each with its own exclusions, skip and truncation applied.
+// Neither input is modified.
+// If neither set has exclusions, skip or truncation, the merged set simply
+// holds the rules and dates of both, and its DTSTART is the one of set, while
+// rules coming from other keep their own DTSTART. Otherwise both sets are
+// kept whole in the merged set: its rules, dates and exclusions, as returned
+// by GetRRule and the like, are initially empty, and exclusions added to it
+// later apply to the union. As RFC 5545 can not express such a set,
+// Recurrence writes each of them between BEGIN:X-RRULE-SET and
+// END:X-RRULE-SET lines, which NewSetFromString parses back.
+func (set *Set) Merge(other *Set) *Set {
+	if !set.flat() || !other.flat() {
+		return &Set{dtstart: set.dtstart, merged: []*Set{set.clone(), other.clone()}}
+	}
+	merged := set.clone()
+	o := other.clone()
+	merged.rrule = append(merged.rrule, o.rrule...)
+	merged.rdate = append(merged.rdate, o.rdate...)
+	merged.exrule = append(merged.exrule, o.exrule...)
+	merged.exdate = append(merged.exdate, o.exdate...)
+	merged.sources = append(merged.sources, o.sources...)
+	return merged
+}
+
+// flat reports whether the occurrences of the set are those of its rules
+// and dates only, without exclusions, skip, truncation or merged sets.
+func (set *Set) flat() bool {
+	return len(set.exrule) == 0 && len(set.exdate) == 0 && len(set.exrange) == 0 &&
+		set.skip == 0 && !set.truncated && len(set.merged) == 0
+}
+
+// Truncate returns a copy of the set which generates at most the first n
+// occurrences of set. Occurrences are not materialized up front.
+// If the set consists of a single RRULE, its string form reflects the
+// truncation as COUNT, and otherwise as an X-RRULE-LIMIT property, which is
+// kept in the component of the set once it is combined by Merge.
+func (set *Set) Truncate(n int) *Set {
+	if n < 0 {
+		n = 0
+	}
+	c := set.clone()
+	if !c.truncated || n < c.limit {
+		c.limit = n
+	}
+	c.truncated = true
+	return c
+}
+
+// Skip returns a copy of the set which omits the first n occurrences of set.
+// Its string form reflects it as an X-RRULE-SKIP property, which is kept in
+// the component of the set once it is combined by Merge.
+func (set *Set) Skip(n int) *Set {
+	if n < 0 {
+		n = 0
+	}
+	c := set.clone()
+	if c.truncated {
+		if n > c.limit {
+			n = c.limit
+		}
+		c.limit -= n
+	}
+	c.skip += n
+	return c
+}
+
+// AddExcludeRange excludes all occurrences strictly between after and before
+// from the set. Ranges are applied while iterating, and are written as
+// X-RRULE-EXRANGE properties in the string representation of the set.
+func (set *Set) AddExcludeRange(after, before time.Time) {
+	set.exrange = append(set.exrange, timeRange{after, before})
+}
+
+// ExcludeRange returns a copy of the set excluding all occurrences strictly
+// between after and before.
+func (set *Set) ExcludeRange(after, before time.Time) *Set {
+	c := set.clone()
+	c.AddExcludeRange(after, before)
+	return c
+}
+
+// Equals reports whether set and other generate the same occurrences.
+// Only the first sampleSize occurrences of each set are compared;
+// a sampleSize of 0 compares all occurrences.
+func (set *Set) Equals(other *Set, sampleSize int) bool {
+	next, otherNext := set.Iterator(), other.Iterator()
+	for i := 0; sampleSize <= 0 || i < sampleSize; i++ {
+		v, ok := next()
+		w, otherOk := otherNext()
+		if ok != otherOk || ok && !v.Equal(w) {
+			return false
+		}
+		if !ok {
+			break
+		}
+	}
+	return true
+}
+
+// OverlapsWith reports whether some occurrence of set and some occurrence of
+// other, both not after before, are less than window apart. The window is
+// typically the duration of the scheduled events.
+func (set *Set) OverlapsWith(other *Set, window time.Duration, before time.Time) bool {
+	next, otherNext := set.Iterator(), other.Iterator()
+	v, ok := next()
+	w, otherOk := otherNext()
+	var last, otherLast time.Time
+	hasLast, hasOtherLast := false, false
+	for {
+		if ok && v.After(before) {
+			ok = false
+		}
+		if otherOk && w.After(before) {
+			otherOk = false
+		}
+		if !ok && !otherOk {
+			return false
+		}
+		if ok && (!otherOk || !w.Before(v)) {
+			if hasOtherLast && v.Sub(otherLast) < window {
+				return true
+			}
+			last, hasLast = v, true
+			v, ok = next()
+		} else {
+			if hasLast && w.Sub(last) < window {
+				return true
+			}
+			otherLast, hasOtherLast = w, true
+			w, otherOk = otherNext()
+		}
+	}
+}
+
+// defaultMaterializeMaxCount is the MaxCount used by MaterializeBetween
+// when it is not set.
+const defaultMaterializeMaxCount = 10000
+
+// MaterializeOptions configures MaterializeBetween.
+type MaterializeOptions struct {
+	// MaxCount is the maximum number of occurrences materialized, 10000 if
+	// it is zero.
+	MaxCount int
+	// IncludeSource keeps the materialized recurrence in the string
+	// representation of the set, as X-RRULE-SOURCE properties.
+	IncludeSource bool
+}
+
+// MaterializeBetween returns a set made of the occurrences of set between
+// after and before, both included, as RDATEs. An error is returned if there
+// are more than options.MaxCount occurrences.
+func (set *Set) MaterializeBetween(after, before time.Time, options MaterializeOptions) (*Set, error) {
+	return materializeBetween(set.Iterator(), set.Recurrence(), after, before, options)
+}
+
+// MaterializeBetween returns a set made of the occurrences of the RRule
+// between after and before, both included, as RDATEs. An error is returned
+// if there are more than options.MaxCount occurrences.
+func (r *RRule) MaterializeBetween(after, before time.Time, options MaterializeOptions) (*Set, error) {
+	return materializeBetween(r.iteratorFrom(after).next, strings.Split(r.String(), "\n"), after, before, options)
+}
+
+func materializeBetween(next Next, sources []string, after, before time.Time, options MaterializeOptions) (*Set, error) {
+	maxCount := options.MaxCount
+	if maxCount <= 0 {
+		maxCount = defaultMaterializeMaxCount
+	}
+	rdates := all(truncateIterator(betweenIterator(next, after, before, true), maxCount+1))
+	if len(rdates) > maxCount {
+		return nil, fmt.Errorf("more than %d occurrences", maxCount)
+	}
+	set := &Set{rdate: rdates}
+	if options.IncludeSource {
+		set.sources = sources
+	}
+	return set, nil
+}
+
+// normalizeSampleSize is the number of occurrences compared by Normalize to
+// detect redundant rules.
+const normalizeSampleSize = 100
+
+// normalizeMaxOccurrences is the largest number of occurrences of a rule
+// that Normalize materializes to check whether the rule is redundant.
+const normalizeMaxOccurrences = 10000
+
+// Normalize returns a copy of the set with sorted RDATEs and EXDATEs without
+// duplicates, and without RRULEs whose occurrences are all generated by
+// another RRULE of the set. Only rules with at most normalizeMaxOccurrences
+// occurrences are removed, after checking every one of them, so that the
+// occurrences of the set are unchanged. The remaining RRULEs are sorted by
+// their string representation.
+func (set *Set) Normalize() *Set {
+	c := set.clone()
+	c.rdate = uniqueTimes(c.rdate)
+	c.exdate = uniqueTimes(c.exdate)
+
+	var rrules []*RRule
+	for i, r := range c.rrule {
+		redundant := false
+		for j, other := range c.rrule {
+			// Of two equivalent rules, the first one is kept.
+			if i != j && isSubset(r, other) && (j < i || !isSubset(other, r)) {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			rrules = append(rrules, r)
+		}
+	}
+	sort.SliceStable(rrules, func(i, j int) bool {
+		return rrules[i].String() < rrules[j].String()
+	})
+	c.rrule = rrules
+	return c
+}
+
+// Compact returns a copy of the set in which RRULEs continuing each other
+// are merged: if the first occurrence a rule would have after its UNTIL is
+// the DTSTART of an otherwise identical rule without COUNT, the first rule
+// takes the UNTIL of the second one, which is removed. Rules are compared
+// on their first normalizeSampleSize occurrences only. Rules which can not
+// be merged are kept as they are.
+func (set *Set) Compact() *Set {
+	c := set.clone()
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(c.rrule) && !merged; i++ {
+			for j := range c.rrule {
+				if r := continuation(c.rrule[i], c.rrule[j]); i != j && r != nil {
+					c.rrule[i] = r
+					c.rrule = append(c.rrule[:j], c.rrule[j+1:]...)
+					merged = true
+					break
+				}
+			}
+		}
+	}
+	return c
+}
+
+// continuation returns r extended up to the UNTIL of next if next continues
+// r, or nil otherwise.
+func continuation(r, next *RRule) *RRule {
+	a, b := r.OrigOptions, next.OrigOptions
+	if r.Count != 0 || next.Count != 0 || a.Until.IsZero() ||
+		r.DateStart.Location().String() != next.DateStart.Location().String() {
+		return nil
+	}
+	a.Dtstart, a.Until, b.Dtstart, b.Until = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+	if a.String() != b.String() {
+		return nil
+	}
+	extended, err := r.with(func(option *ROption) { option.Until = next.OrigOptions.Until })
+	if err != nil || !extended.After(r.UntilTime, false).Equal(next.DateStart) {
+		return nil
+	}
+	sample, truncated := next.Materialize(normalizeSampleSize)
+	var continued []time.Time
+	if truncated {
+		continued, err = extended.GenerateN(next.DateStart, len(sample))
+	} else {
+		continued = extended.AllAfter(next.DateStart, true)
+	}
+	if err != nil || len(continued) != len(sample) {
+		return nil
+	}
+	for i := range sample {
+		if !continued[i].Equal(sample[i]) {
+			return nil
+		}
+	}
+	return extended
+}
+
+// isSubset reports whether r has at most normalizeMaxOccurrences
+// occurrences, all of which are occurrences of other.
+func isSubset(r, other *RRule) bool {
+	occurrences, truncated := r.Materialize(normalizeMaxOccurrences)
+	if truncated {
+		return false
+	}
+	next := other.Iterator()
+	v, ok := next()
+	for _, t := range occurrences {
+		for ok && v.Before(t) {
+			v, ok = next()
+		}
+		if !ok || !v.Equal(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeIterators merges sorted iterators into a single sorted iterator,
+// using a min-heap. Occurrences generated by several iterators are
+// returned once.
+func mergeIterators(nexts ...Next) Next {
+	h := genItemSlice{}
+	for _, next := range nexts {
+		addGenList((*[]genItem)(&h), next)
+	}
+	heap.Init(&h)
+	var last time.Time
+	started := false
+	return func() (time.Time, bool) {
+		for len(h) != 0 {
+			dt := h[0].dt
+			if v, ok := h[0].gen(); ok {
+				h[0].dt = v
+				heap.Fix(&h, 0)
+			} else {
+				heap.Pop(&h)
+			}
+			if !started || !dt.Equal(last) {
+				started, last = true, dt
+				return dt, true
+			}
+		}
+		return time.Time{}, false
+	}
+}
+
+// exceptIterator returns the occurrences of next which are not generated
+// by any of exclusions. All iterators are advanced in parallel.
+func exceptIterator(next Next, exclusions ...Next) Next {
+	exclude := mergeIterators(exclusions...)
+	ex, exOK := exclude()
+	return func() (time.Time, bool) {
+		for {
+			v, ok := next()
+			if !ok {
+				return v, ok
+			}
+			for exOK && ex.Before(v) {
+				ex, exOK = exclude()
+			}
+			if !exOK || !ex.Equal(v) {
+				return v, true
+			}
+		}
+	}
+}
+
+// SortedMerge returns the occurrences of set and other up to and including
+// before, merged in order. Occurrences of both sets are returned once.
+func (set *Set) SortedMerge(other *Set, before time.Time) ([]time.Time, error) {
+	if other == nil {
+		return nil, errors.New("other set is nil")
+	}
+	next := mergeIterators(set.Iterator(), other.Iterator())
+	return allBefore(next, before, true), nil
+}
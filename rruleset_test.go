@@ -1,6 +1,7 @@
 package rrule
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -53,12 +54,9 @@ func TestSetString(t *testing.T) {
 	set.RDate(time.Date(1997, 9, 9, 9, 0, 0, 0, time.UTC))
 
 	want := `RRULE:FREQ=YEARLY;DTSTART=19970902T090000Z;COUNT=1;BYDAY=TU
-RDATE:19970904T090000Z
-RDATE:19970909T090000Z
+RDATE:19970904T090000Z,19970909T090000Z
 EXRULE:FREQ=YEARLY;DTSTART=19970902T090000Z;COUNT=3;BYDAY=TH
-EXDATE:19970904T090000Z
-EXDATE:19970911T090000Z
-EXDATE:19970918T090000Z`
+EXDATE:19970904T090000Z,19970911T090000Z,19970918T090000Z`
 	value := set.String()
 	if want != value {
 		t.Errorf("get %v, want %v", value, want)
@@ -96,12 +94,9 @@ func TestSetRFCString(t *testing.T) {
 
 	want := `DTSTART;TZID=America/New_York:19970902T090000
 RRULE:FREQ=YEARLY;COUNT=1;BYDAY=TU
-RDATE:19970904T090000Z
-RDATE:19970909T090000Z
+RDATE:19970904T090000Z,19970909T090000Z
 EXRULE:FREQ=YEARLY;COUNT=3;BYDAY=TH
-EXDATE:19970904T090000Z
-EXDATE:19970911T090000Z
-EXDATE:19970918T090000Z`
+EXDATE:19970904T090000Z,19970911T090000Z,19970918T090000Z`
 	value := set.String()
 	if want != value {
 		t.Errorf("get \n%v\n want \n%v\n", value, want)
@@ -184,6 +179,59 @@ func TestSetExRule(t *testing.T) {
 	}
 }
 
+func TestSetCollapseExRules(t *testing.T) {
+	set := Set{}
+	r, _ := NewRRule(ROption{Freq: YEARLY, Count: 6, Byweekday: []Weekday{TU, TH},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	r, _ = NewRRule(ROption{Freq: YEARLY, Count: 3, Byweekday: []Weekday{TH},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.ExRule(r)
+	want := set.All()
+	if err := set.CollapseExRules(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.GetExRule()) != 0 || len(set.GetExDate()) != 3 {
+		t.Errorf("get %v and %v, want only exdates", set.GetExRule(), set.GetExDate())
+	}
+	if value := set.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	r, _ = NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{TU},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.ExRule(r)
+	if err := set.CollapseExRules(time.Time{}); err == nil {
+		t.Error("get nil error for unbounded exrule, want error")
+	}
+	if len(set.GetExRule()) != 1 {
+		t.Error("CollapseExRules modified the set on error")
+	}
+	if err := set.CollapseExRules(time.Date(1997, 9, 9, 9, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	value := set.All()
+	want = []time.Time{time.Date(1997, 9, 16, 9, 0, 0, 0, time.UTC)}
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	// Existing EXDATEs keep their insertion order.
+	set = Set{}
+	set.ExDate(time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC))
+	set.ExDate(time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC))
+	r, _ = NewRRule(ROption{Freq: DAILY, Count: 3, Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.ExRule(r)
+	if err := set.CollapseExRules(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	want = []time.Time{time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC), time.Date(1997, 9, 3, 9, 0, 0, 0, time.UTC)}
+	if value := set.GetExDate(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+}
+
 func TestSetExDate(t *testing.T) {
 	set := Set{}
 	r, _ := NewRRule(ROption{Freq: YEARLY, Count: 6, Byweekday: []Weekday{TU, TH},
@@ -300,6 +348,24 @@ func TestSetBeforeInc(t *testing.T) {
 	}
 }
 
+func TestSetBeforeWithExclusions(t *testing.T) {
+	set := Set{}
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 7,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	set.ExDate(time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC))
+	set.RDate(time.Date(1997, 9, 3, 12, 0, 0, 0, time.UTC))
+	want := time.Date(1997, 9, 3, 12, 0, 0, 0, time.UTC)
+	value := set.Before(time.Date(1997, 9, 5, 9, 0, 0, 0, time.UTC), false)
+	if value != want {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	value = set.Before(time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC), true)
+	if value != want {
+		t.Errorf("get %v, want %v", value, want)
+	}
+}
+
 func TestSetAfter(t *testing.T) {
 	set := Set{}
 	r, _ := NewRRule(ROption{Freq: DAILY, Count: 7,
@@ -324,6 +390,30 @@ func TestSetAfterInc(t *testing.T) {
 	}
 }
 
+func TestSetAfterWithExclusions(t *testing.T) {
+	set := Set{}
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 7,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	set.ExDate(time.Date(1997, 9, 5, 9, 0, 0, 0, time.UTC))
+	r, _ = NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{SA},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.ExRule(r)
+	want := time.Date(1997, 9, 7, 9, 0, 0, 0, time.UTC)
+	value := set.After(time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC), false)
+	if value != want {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	value = set.After(time.Date(1997, 9, 5, 9, 0, 0, 0, time.UTC), true)
+	if value != want {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	value = set.After(time.Date(1997, 9, 8, 9, 0, 0, 0, time.UTC), false)
+	if !value.IsZero() {
+		t.Errorf("get %v, want zero time", value)
+	}
+}
+
 func TestSetBetween(t *testing.T) {
 	set := Set{}
 	r, _ := NewRRule(ROption{Freq: DAILY, Count: 7,
@@ -440,3 +530,772 @@ func TestRuleSetChangeDTStartTimezoneRespected(t *testing.T) {
 		}
 	}
 }
+
+func TestSetMerge(t *testing.T) {
+	set1 := Set{}
+	set1.DTStart(time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC))
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 2})
+	set1.RRule(r)
+	set2 := Set{}
+	r, _ = NewRRule(ROption{Freq: WEEKLY, Count: 2,
+		Dtstart: time.Date(1997, 9, 3, 10, 0, 0, 0, time.UTC)})
+	set2.RRule(r)
+	set2.RDate(time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC))
+
+	merged := set1.Merge(&set2)
+	want := []time.Time{time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 3, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 3, 10, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 10, 10, 0, 0, 0, time.UTC)}
+	value := merged.All()
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if merged.GetDTStart() != set1.GetDTStart() {
+		t.Errorf("get %v, want %v", merged.GetDTStart(), set1.GetDTStart())
+	}
+
+	merged.GetRRule()[0].DTStart(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	merged.RDate(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	if len(set1.All()) != 2 || len(set2.All()) != 3 {
+		t.Errorf("Merge should not share state with its inputs")
+	}
+}
+
+func TestSetMergeExclusions(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	set1 := Set{}
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 3, Dtstart: dtstart})
+	set1.RRule(r)
+	set1.ExDate(dtstart.AddDate(0, 0, 1))
+	set2 := Set{}
+	r, _ = NewRRule(ROption{Freq: WEEKLY, Dtstart: dtstart.Add(time.Hour)})
+	set2.RRule(r)
+	set2.RDate(dtstart.AddDate(0, 0, 1))
+	set2.ExDate(dtstart)
+
+	merged := set1.Merge(set2.Truncate(2))
+	want := []time.Time{dtstart, dtstart.Add(time.Hour), dtstart.AddDate(0, 0, 1), dtstart.AddDate(0, 0, 2)}
+	if value := merged.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value := merged.Between(dtstart, dtstart.AddDate(0, 0, 1), true); !timesEqual(value, want[:3]) {
+		t.Errorf("get %v, want %v", value, want[:3])
+	}
+
+	// Exclusions and truncation of the merged set apply to the union.
+	merged.ExDate(dtstart.Add(time.Hour))
+	merged = merged.Truncate(2)
+	want = []time.Time{dtstart, dtstart.AddDate(0, 0, 1)}
+	if value := merged.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if len(set1.All()) != 2 || len(set2.Truncate(3).All()) != 3 {
+		t.Errorf("Merge should not share state with its inputs")
+	}
+}
+
+func TestSetMergeString(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 10, Dtstart: dtstart})
+	a, _ := NewRRuleSet(dtstart, r)
+	b := &Set{}
+	b.RDate(dtstart.AddDate(0, 0, 20))
+	excluding := a.clone()
+	excluding.ExDate(dtstart.AddDate(0, 0, 1))
+	including := &Set{}
+	including.RDate(dtstart.AddDate(0, 0, 1))
+	nested := excluding.Merge(including).Merge(a.Truncate(2))
+	nested.ExDate(dtstart)
+
+	for _, c := range []struct {
+		set    *Set
+		length int
+	}{
+		{a.Truncate(3).Merge(b), 4},
+		{excluding.Merge(including), 10},
+		{nested, 9},
+	} {
+		if value := c.set.All(); len(value) != c.length {
+			t.Errorf("get %d occurrences, want %d", len(value), c.length)
+		}
+		parsed, err := NewSetFromString(c.set.String())
+		if err != nil {
+			t.Fatalf("%q: %v", c.set, err)
+		}
+		if value, want := parsed.All(), c.set.All(); !timesEqual(value, want) {
+			t.Errorf("%q: get %v, want %v", c.set, value, want)
+		}
+		if value, want := parsed.String(), c.set.String(); value != want {
+			t.Errorf("get %q, want %q", value, want)
+		}
+	}
+
+	for _, s := range []string{
+		"DTSTART:19970902T090000Z\nBEGIN:X-RRULE-SET\nRDATE:19970903T090000Z",
+		"DTSTART:19970902T090000Z\nEND:X-RRULE-SET",
+		"BEGIN:X-RRULE-SET\nRRULE:FREQ=DAILY;BYHOUR=25\nEND:X-RRULE-SET",
+	} {
+		if _, err := NewSetFromString(s); err == nil {
+			t.Errorf("%q: get nil, want error", s)
+		}
+	}
+}
+
+func TestSetTruncate(t *testing.T) {
+	set := Set{}
+	r, _ := NewRRule(ROption{Freq: DAILY,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	truncated := set.Truncate(3)
+	want := []time.Time{time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 3, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC)}
+	value := truncated.All()
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	wantStr := "RRULE:FREQ=DAILY;DTSTART=19970902T090000Z;COUNT=3"
+	if s := truncated.String(); s != wantStr {
+		t.Errorf("get %v, want %v", s, wantStr)
+	}
+
+	r, _ = NewRRule(ROption{Freq: DAILY, Count: 2,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set = Set{}
+	set.RRule(r)
+	if value := set.Truncate(5).All(); !timesEqual(value, want[:2]) {
+		t.Errorf("get %v, want %v", value, want[:2])
+	}
+}
+
+func TestSetSkip(t *testing.T) {
+	set := Set{}
+	r, _ := NewRRule(ROption{Freq: DAILY,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	want := []time.Time{time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 5, 9, 0, 0, 0, time.UTC)}
+	value := set.Skip(2).Truncate(2).All()
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	value = set.Truncate(4).Skip(2).All()
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+}
+
+func TestSetTruncateSkipString(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	daily, _ := NewRRule(ROption{Freq: DAILY, Dtstart: dtstart})
+	weekly, _ := NewRRule(ROption{Freq: WEEKLY, Dtstart: dtstart.Add(time.Hour)})
+	set := &Set{}
+	set.RRule(daily)
+	set.RRule(weekly)
+	single := &Set{}
+	single.RRule(daily)
+
+	for _, c := range []struct {
+		set  *Set
+		want string
+	}{
+		{set.Truncate(3), "X-RRULE-LIMIT:3"},
+		{set.Skip(2), "X-RRULE-SKIP:2"},
+		{set.Skip(2).Truncate(3), "X-RRULE-SKIP:2\nX-RRULE-LIMIT:3"},
+		{single.Skip(2).Truncate(3), "X-RRULE-SKIP:2\nX-RRULE-LIMIT:3"},
+		{set.Truncate(0), "X-RRULE-LIMIT:0"},
+		// Skip and truncation of merged sets are written in their component.
+		{single.Merge(set.Skip(2)), "X-RRULE-SKIP:2\nEND:X-RRULE-SET"},
+		{single.Merge(set.Skip(1).Truncate(2)), "X-RRULE-SKIP:1\nX-RRULE-LIMIT:2\nEND:X-RRULE-SET"},
+		{set.Truncate(2).Merge(single.Skip(1)).Truncate(3), "END:X-RRULE-SET\nX-RRULE-LIMIT:3"},
+	} {
+		s := c.set.String()
+		if !strings.HasSuffix(s, "\n"+c.want) {
+			t.Errorf("get %q, want it to end with %q", s, c.want)
+		}
+		parsed, err := NewSetFromString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := c.set.Between(dtstart, dtstart.AddDate(0, 1, 0), true)
+		if value := parsed.Between(dtstart, dtstart.AddDate(0, 1, 0), true); !timesEqual(value, want) {
+			t.Errorf("NewSetFromString(%q) gets %v, want %v", s, value, want)
+		}
+	}
+	for _, s := range []string{"RRULE:FREQ=DAILY\nX-RRULE-LIMIT:-1", "RRULE:FREQ=DAILY\nX-RRULE-SKIP:x"} {
+		if _, err := NewSetFromString(s); err == nil {
+			t.Errorf("NewSetFromString(%q) = nil error, want error", s)
+		}
+	}
+}
+
+func TestNewRRuleSet(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 2})
+	set, err := NewRRuleSet(dtstart, r)
+	if err != nil {
+		t.Fatal("expected", nil, "got", err)
+	}
+	want := []time.Time{dtstart, time.Date(1997, 9, 3, 9, 0, 0, 0, time.UTC)}
+	if value := set.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	if _, err := NewRRuleSet(time.Time{}, r); err == nil {
+		t.Error("get nil, want error for zero dtstart")
+	}
+	if _, err := NewRRuleSet(dtstart, r, nil); err == nil {
+		t.Error("get nil, want error for nil rule")
+	}
+	loc, err := time.LoadLocation("CET")
+	if err != nil {
+		t.Fatal("expected", nil, "got", err)
+	}
+	r, _ = NewRRule(ROption{Freq: DAILY, Count: 2, Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, loc)})
+	if _, err := NewRRuleSet(dtstart, r); err == nil {
+		t.Error("get nil, want error for incompatible DTSTART")
+	}
+}
+
+func TestSetExcludeRange(t *testing.T) {
+	set := Set{}
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 5,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	excluded := set.ExcludeRange(time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 5, 9, 0, 0, 0, time.UTC))
+	want := []time.Time{time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 5, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 6, 9, 0, 0, 0, time.UTC)}
+	value := excluded.All()
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if len(set.All()) != 5 {
+		t.Errorf("ExcludeRange should not modify the set")
+	}
+
+	set.AddExcludeRange(time.Date(1997, 9, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 4, 0, 0, 0, 0, time.UTC))
+	want = []time.Time{time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 5, 9, 0, 0, 0, time.UTC),
+		time.Date(1997, 9, 6, 9, 0, 0, 0, time.UTC)}
+	value = set.All()
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+}
+
+func TestSetExcludeRangeString(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 10, Dtstart: dtstart})
+	set, _ := NewRRuleSet(dtstart, r)
+	ny, _ := time.LoadLocation("America/New_York")
+	// Bounds are rounded outwards to the second: 09:00 on the 3rd and on
+	// the 6th stay excluded.
+	set.AddExcludeRange(time.Date(1997, 9, 3, 4, 59, 59, 500, ny),
+		time.Date(1997, 9, 6, 9, 0, 0, 1, time.UTC))
+	set.AddExcludeRange(time.Date(1997, 9, 8, 9, 0, 0, 0, time.UTC), time.Time{}.AddDate(9000, 0, 0))
+
+	s := set.String()
+	want := "X-RRULE-EXRANGE:19970903T085959Z/19970906T090001Z\nX-RRULE-EXRANGE:19970908T090000Z/90010101T000000Z"
+	if !strings.HasSuffix(s, "\n"+want) {
+		t.Errorf("get %q, want it to end with %q", s, want)
+	}
+	for _, set := range []*Set{set, set.Truncate(3), set.Merge(set.Skip(1))} {
+		s := set.String()
+		parsed, err := NewSetFromString(s)
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if value, want := parsed.All(), set.All(); !timesEqual(value, want) {
+			t.Errorf("%q: get %v, want %v", s, value, want)
+		}
+	}
+	if value := len(set.All()); value != 3 {
+		t.Errorf("get %d occurrences, want 3", value)
+	}
+
+	for _, s := range []string{
+		"RRULE:FREQ=DAILY\nX-RRULE-EXRANGE:19970903T090000Z",
+		"RRULE:FREQ=DAILY\nX-RRULE-EXRANGE:19970903T090000Z/x",
+	} {
+		if _, err := NewSetFromString(s); err == nil {
+			t.Errorf("NewSetFromString(%q) = nil error, want error", s)
+		}
+	}
+}
+
+func TestSetEquals(t *testing.T) {
+	set1 := Set{}
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 3,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set1.RRule(r)
+	set2 := Set{}
+	set2.RDate(time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC))
+	set2.RDate(time.Date(1997, 9, 3, 9, 0, 0, 0, time.UTC))
+	set2.RDate(time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC))
+	if !set1.Equals(&set2, 0) {
+		t.Error("get false, want true")
+	}
+	set2.RDate(time.Date(1997, 9, 5, 9, 0, 0, 0, time.UTC))
+	if set1.Equals(&set2, 0) {
+		t.Error("get true, want false")
+	}
+	if !set1.Equals(&set2, 3) {
+		t.Error("get false, want true when sampling 3 occurrences")
+	}
+	if !(&Set{}).Equals(&Set{}, 100) {
+		t.Error("get false, want true for empty sets")
+	}
+}
+
+func TestSetGettersReturnCopies(t *testing.T) {
+	set := Set{}
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 1,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	set.ExRule(r)
+	set.RDate(time.Date(1997, 9, 3, 9, 0, 0, 0, time.UTC))
+	set.ExDate(time.Date(1997, 9, 3, 9, 0, 0, 0, time.UTC))
+
+	set.GetRRule()[0] = nil
+	set.GetExRule()[0] = nil
+	set.GetRDate()[0] = time.Time{}
+	set.GetExDate()[0] = time.Time{}
+	if set.GetRRule()[0] != r || set.GetExRule()[0] != r {
+		t.Error("modifying the returned rules should not modify the set")
+	}
+	if set.GetRDate()[0].IsZero() || set.GetExDate()[0].IsZero() {
+		t.Error("modifying the returned dates should not modify the set")
+	}
+}
+
+func TestSetAddRemoveExcludeDate(t *testing.T) {
+	set := Set{}
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 3,
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	d1, d2 := time.Date(1997, 9, 4, 9, 0, 0, 0, time.UTC), time.Date(1997, 9, 3, 9, 0, 0, 0, time.UTC)
+	if err := set.AddExcludeDate(d1); err != nil {
+		t.Fatal("expected", nil, "got", err)
+	}
+	if err := set.AddExcludeDate(d2); err != nil {
+		t.Fatal("expected", nil, "got", err)
+	}
+	if err := set.AddExcludeDate(d1.In(time.FixedZone("X", 3600))); err != ErrAlreadyExists {
+		t.Errorf("get %v, want %v", err, ErrAlreadyExists)
+	}
+	if value := set.GetExDate(); !timesEqual(value, []time.Time{d2, d1}) {
+		t.Errorf("get %v, want %v", value, []time.Time{d2, d1})
+	}
+	if !set.RemoveExcludeDate(d2) || set.RemoveExcludeDate(d2) {
+		t.Error("RemoveExcludeDate should remove the date exactly once")
+	}
+	want := []time.Time{time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC), d2}
+	if value := set.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	if err := set.AddRDate(d1); err != nil || set.AddRDate(d1) != ErrAlreadyExists {
+		t.Error("AddRDate should add the date exactly once")
+	}
+	if !set.RemoveRDate(d1) || len(set.GetRDate()) != 0 {
+		t.Error("RemoveRDate should remove the date")
+	}
+}
+
+func TestSetOverlapsWith(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	daily, _ := NewRRule(ROption{Freq: DAILY, Dtstart: dtstart})
+	weekly, _ := NewRRule(ROption{Freq: WEEKLY, Dtstart: dtstart.Add(90 * time.Minute)})
+	set := &Set{}
+	set.RRule(daily)
+	other := &Set{}
+	other.RRule(weekly)
+	before := dtstart.AddDate(1, 0, 0)
+
+	if set.OverlapsWith(other, time.Hour, before) {
+		t.Error("get true, want false")
+	}
+	if !set.OverlapsWith(other, 2*time.Hour, before) {
+		t.Error("get false, want true")
+	}
+	if !other.OverlapsWith(set, 2*time.Hour, before) {
+		t.Error("get false, want true")
+	}
+	if set.OverlapsWith(other, 2*time.Hour, dtstart.Add(time.Hour)) {
+		t.Error("get true, want false")
+	}
+}
+
+func TestSetAllWithDeduplicate(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 3, Dtstart: dtstart})
+	set := &Set{}
+	set.RRule(r)
+	set.RRule(r)
+	set.RDate(dtstart.AddDate(0, 0, 1))
+	set.RDate(dtstart.AddDate(0, 0, 1).In(time.FixedZone("X", 3600)))
+	want := []time.Time{dtstart, dtstart.AddDate(0, 0, 1), dtstart.AddDate(0, 0, 2)}
+	if value := set.AllWithDeduplicate(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value := set.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value := (&Set{}).AllWithDeduplicate(); len(value) != 0 {
+		t.Errorf("get %v, want []", value)
+	}
+}
+
+func TestSetInferDTStart(t *testing.T) {
+	set := &Set{}
+	if set.HasDTStart() || !set.InferDTStart().IsZero() {
+		t.Errorf("get %v and %v, want false and zero time", set.HasDTStart(), set.InferDTStart())
+	}
+
+	early := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	r1, _ := NewRRule(ROption{Freq: DAILY, Dtstart: early.AddDate(0, 1, 0)})
+	r2, _ := NewRRule(ROption{Freq: WEEKLY, Dtstart: early})
+	set.RRule(r1)
+	set.ExRule(r2)
+	if set.HasDTStart() || !set.GetDTStart().IsZero() {
+		t.Errorf("get %v and %v, want false and zero time", set.HasDTStart(), set.GetDTStart())
+	}
+	if value := set.InferDTStart(); !value.Equal(early) {
+		t.Errorf("get %v, want %v", value, early)
+	}
+
+	dtstart := early.AddDate(1, 0, 0)
+	set.DTStart(dtstart)
+	if !set.HasDTStart() || !set.InferDTStart().Equal(dtstart) {
+		t.Errorf("get %v and %v, want true and %v", set.HasDTStart(), set.InferDTStart(), dtstart)
+	}
+}
+
+func TestSetStrExDateValueDate(t *testing.T) {
+	str := "DTSTART:20180101T000000Z\nRRULE:FREQ=DAILY;COUNT=5\nEXDATE;VALUE=DATE:20180102,20180104"
+	set, err := StrToRRuleSet(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := set.String(); value != str {
+		t.Errorf("get %q, want %q", value, str)
+	}
+	want := []time.Time{
+		time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC),
+		time.Date(2018, 1, 5, 0, 0, 0, 0, time.UTC),
+	}
+	if value := set.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	set.ExDate(time.Date(2018, 1, 5, 9, 0, 0, 0, time.UTC))
+	want2 := "DTSTART:20180101T000000Z\nRRULE:FREQ=DAILY;COUNT=5\n" +
+		"EXDATE:20180102T000000Z,20180104T000000Z,20180105T090000Z"
+	if value := set.String(); value != want2 {
+		t.Errorf("get %q, want %q", value, want2)
+	}
+}
+
+func TestSetAllAfterBefore(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 5, Dtstart: dtstart})
+	set := &Set{}
+	set.RRule(r)
+	set.ExDate(dtstart.AddDate(0, 0, 3))
+	all := set.All()
+	if value := set.AllAfter(all[1], false); !timesEqual(value, all[2:]) {
+		t.Errorf("get %v, want %v", value, all[2:])
+	}
+	if value := set.AllBefore(all[1], true); !timesEqual(value, all[:2]) {
+		t.Errorf("get %v, want %v", value, all[:2])
+	}
+}
+
+func TestSetSortedMerge(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	daily, _ := NewRRule(ROption{Freq: DAILY, Interval: 2, Dtstart: dtstart})
+	weekly, _ := NewRRule(ROption{Freq: WEEKLY, Dtstart: dtstart.Add(time.Hour)})
+	set := &Set{}
+	set.RRule(daily)
+	other := &Set{}
+	other.RRule(weekly)
+	other.RDate(dtstart.AddDate(0, 0, 4))
+
+	before := dtstart.AddDate(0, 0, 8)
+	value, err := set.SortedMerge(other, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{
+		dtstart, dtstart.Add(time.Hour), dtstart.AddDate(0, 0, 2), dtstart.AddDate(0, 0, 4),
+		dtstart.AddDate(0, 0, 6), dtstart.AddDate(0, 0, 7).Add(time.Hour), dtstart.AddDate(0, 0, 8),
+	}
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	if _, err := set.SortedMerge(nil, before); err == nil {
+		t.Error("get nil error, want error")
+	}
+}
+
+func TestSetNormalize(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	daily, _ := NewRRule(ROption{Freq: DAILY, Count: 200, Dtstart: dtstart})
+	weekly, _ := NewRRule(ROption{Freq: WEEKLY, Count: 10, Dtstart: dtstart})
+	daily2, _ := NewRRule(ROption{Freq: DAILY, Count: 200, Dtstart: dtstart})
+	monthly, _ := NewRRule(ROption{Freq: MONTHLY, Count: 3, Dtstart: dtstart.Add(time.Hour)})
+	set := &Set{}
+	set.RRule(monthly)
+	set.RRule(weekly)
+	set.RRule(daily)
+	set.RRule(daily2)
+	set.RDate(dtstart.AddDate(0, 0, 2))
+	set.RDate(dtstart)
+	set.RDate(dtstart.AddDate(0, 0, 2).In(time.FixedZone("X", 3600)))
+	set.ExDate(dtstart.AddDate(0, 0, 5))
+	set.ExDate(dtstart.AddDate(0, 0, 1))
+	set.ExDate(dtstart.AddDate(0, 0, 5))
+
+	normalized := set.Normalize()
+	rrules := normalized.GetRRule()
+	if len(rrules) != 2 || rrules[0].String() != daily.String() || rrules[1].String() != monthly.String() {
+		t.Errorf("get %v, want [%v %v]", rrules, daily, monthly)
+	}
+	if want := []time.Time{dtstart, dtstart.AddDate(0, 0, 2)}; !timesEqual(normalized.GetRDate(), want) {
+		t.Errorf("get %v, want %v", normalized.GetRDate(), want)
+	}
+	if want := []time.Time{dtstart.AddDate(0, 0, 1), dtstart.AddDate(0, 0, 5)}; !timesEqual(normalized.GetExDate(), want) {
+		t.Errorf("get %v, want %v", normalized.GetExDate(), want)
+	}
+	if !normalized.Equals(set, 0) {
+		t.Errorf("get %v, want the occurrences of %v", normalized.All(), set.All())
+	}
+	if len(set.GetRRule()) != 4 || len(set.GetRDate()) != 3 {
+		t.Error("Normalize modified the set")
+	}
+}
+
+func TestSetNormalizeUnbounded(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	bounded, _ := NewRRule(ROption{Freq: DAILY, Count: 100, Dtstart: dtstart})
+	unbounded, _ := NewRRule(ROption{Freq: DAILY, Dtstart: dtstart})
+	set := &Set{}
+	set.RRule(bounded)
+	set.RRule(unbounded)
+
+	normalized := set.Normalize()
+	if rrules := normalized.GetRRule(); len(rrules) != 1 || rrules[0].String() != unbounded.String() {
+		t.Errorf("get %v, want [%v]", rrules, unbounded)
+	}
+	after, before := dtstart, dtstart.AddDate(1, 0, 0)
+	if value := len(normalized.Between(after, before, true)); value != 366 {
+		t.Errorf("get %v occurrences, want 366", value)
+	}
+
+	// Rules with more occurrences than can be checked are all kept.
+	large, _ := NewRRule(ROption{Freq: DAILY, Count: normalizeMaxOccurrences + 1, Dtstart: dtstart})
+	set = &Set{}
+	set.RRule(large)
+	set.RRule(unbounded)
+	if rrules := set.Normalize().GetRRule(); len(rrules) != 2 {
+		t.Errorf("get %v, want both rules", rrules)
+	}
+}
+
+func TestSetIteratorNext(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 5, Dtstart: dtstart})
+	set := &Set{}
+	set.RRule(r)
+	set.RDate(dtstart.Add(time.Hour))
+	set.ExDate(dtstart.AddDate(0, 0, 1))
+
+	after, before := dtstart, dtstart.AddDate(0, 0, 3)
+	for _, c := range []struct {
+		next Next
+		want []time.Time
+	}{
+		{r.Iterator(), r.Between(after, before, true)},
+		{set.Iterator(), set.Between(after, before, true)},
+	} {
+		if value := between(c.next, after, before, true); !timesEqual(value, c.want) {
+			t.Errorf("get %v, want %v", value, c.want)
+		}
+	}
+}
+
+func TestSetStringGroupsDates(t *testing.T) {
+	set := Set{}
+	var want []string
+	for i := 0; i < 10; i++ {
+		d := time.Date(2018, 1, 1+i, 9, 0, 0, 0, time.UTC)
+		set.RDate(d)
+		want = append(want, timeToStr(d))
+	}
+	if value, want := set.String(), "RDATE:"+strings.Join(want, ","); value != want {
+		t.Errorf("get %q, want %q", value, want)
+	}
+
+	ny, _ := time.LoadLocation("America/New_York")
+	set.RDate(time.Date(2018, 2, 1, 9, 0, 0, 0, ny))
+	set.RDate(time.Date(2018, 2, 1, 9, 0, 0, 0, time.FixedZone("UTC+1", 3600)))
+	set.RDate(time.Date(2018, 2, 2, 9, 0, 0, 0, ny))
+	lines := strings.Split(set.String(), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], ",20180201T080000Z") ||
+		lines[1] != "RDATE;TZID=America/New_York:20180201T090000,20180202T090000" {
+		t.Errorf("get %q", lines)
+	}
+	parsed, err := NewSetFromString(set.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, want := parsed.All(), set.All(); len(value) != len(want) || !value[11].Equal(want[11]) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+}
+
+func TestSetCompact(t *testing.T) {
+	set := Set{}
+	first, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO, WE},
+		Dtstart: time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC), Until: time.Date(2018, 1, 31, 9, 0, 0, 0, time.UTC)})
+	second, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO, WE},
+		Dtstart: time.Date(2018, 2, 5, 9, 0, 0, 0, time.UTC), Until: time.Date(2018, 3, 31, 0, 0, 0, 0, time.UTC)})
+	third, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO, WE},
+		Dtstart: time.Date(2018, 4, 2, 9, 0, 0, 0, time.UTC)})
+	set.RRule(third)
+	set.RRule(first)
+	set.RRule(second)
+	compact := set.Compact()
+	if rules := compact.GetRRule(); len(rules) != 1 || !rules[0].OrigOptions.Until.IsZero() {
+		t.Errorf("get %v, want a single unbounded rule", compact)
+	}
+	before := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	if value, want := compact.Between(first.DateStart, before, true), set.Between(first.DateStart, before, true); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if len(set.GetRRule()) != 3 {
+		t.Error("Compact modified the set")
+	}
+
+	set = Set{}
+	set.RRule(first)
+	// Starting one week later than the next occurrence of first.
+	gap, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO, WE},
+		Dtstart: time.Date(2018, 2, 12, 9, 0, 0, 0, time.UTC), Count: 3})
+	set.RRule(gap)
+	other, _ := NewRRule(ROption{Freq: WEEKLY, Byweekday: []Weekday{MO},
+		Dtstart: time.Date(2018, 2, 5, 9, 0, 0, 0, time.UTC)})
+	set.RRule(other)
+	if rules := set.Compact().GetRRule(); len(rules) != 3 {
+		t.Errorf("get %v, want rules kept as they are", rules)
+	}
+}
+
+func TestMaterializeBetween(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	r, _ := NewRRule(ROption{Freq: DAILY, Dtstart: dtstart})
+	after, before := dtstart.AddDate(0, 0, 1), dtstart.AddDate(0, 0, 3)
+	set, err := r.MaterializeBetween(after, before, MaterializeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := r.Between(after, before, true)
+	if value := set.All(); !timesEqual(value, want) || len(set.GetRRule()) != 0 {
+		t.Errorf("get %v, want %v as RDATEs", set, want)
+	}
+	if value, want := set.String(), "RDATE:20180102T090000Z,20180103T090000Z,20180104T090000Z"; value != want {
+		t.Errorf("get %q, want %q", value, want)
+	}
+
+	set, _ = r.MaterializeBetween(after, before, MaterializeOptions{IncludeSource: true})
+	str := set.String()
+	if want := "\nX-RRULE-SOURCE:FREQ=DAILY;DTSTART=20180101T090000Z"; !strings.HasSuffix(str, want) {
+		t.Errorf("get %q, want suffix %q", str, want)
+	}
+	parsed, err := NewSetFromString(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != str {
+		t.Errorf("get %q, want %q", parsed, str)
+	}
+
+	if _, err := r.MaterializeBetween(after, before, MaterializeOptions{MaxCount: 2}); err == nil {
+		t.Error("get nil error for more than MaxCount occurrences, want error")
+	}
+
+	source := Set{}
+	source.RRule(r)
+	source.ExDate(dtstart.AddDate(0, 0, 2))
+	set, err = source.MaterializeBetween(after, before, MaterializeOptions{MaxCount: 2, IncludeSource: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, want := set.All(), source.Between(after, before, true); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if len(set.sources) != 2 {
+		t.Errorf("get sources %q, want RRULE and EXDATE", set.sources)
+	}
+}
+
+func TestSetStringDTStart(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	cases := []struct {
+		dtstart time.Time
+		want    string
+	}{
+		{time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC), "DTSTART:20180101T090000Z"},
+		{time.Date(2018, 1, 1, 9, 0, 0, 0, ny), "DTSTART;TZID=America/New_York:20180101T090000"},
+		{time.Date(2018, 1, 1, 9, 0, 0, 0, time.FixedZone("UTC+8", 8*3600)), "DTSTART:20180101T010000Z"},
+	}
+	for _, c := range cases {
+		set := Set{}
+		set.DTStart(c.dtstart)
+		r, _ := NewRRule(ROption{Freq: DAILY, Count: 2, RFC: true})
+		set.RRule(r)
+		str := set.String()
+		if want := c.want + "\nRRULE:FREQ=DAILY;COUNT=2"; str != want {
+			t.Errorf("get %q, want %q", str, want)
+		}
+		parsed, err := NewSetFromString(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value := parsed.GetDTStart(); !value.Equal(c.dtstart) {
+			t.Errorf("get %v, want %v", value, c.dtstart)
+		}
+	}
+}
+
+func TestSetDebugString(t *testing.T) {
+	set := Set{}
+	set.DTStart(time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC))
+	r, _ := NewRRule(ROption{Freq: WEEKLY, Interval: 2, Byweekday: []Weekday{MO, FR.Nth(-1)}})
+	set.RRule(r)
+	r, _ = NewRRule(ROption{Freq: DAILY, Count: 1})
+	set.ExRule(r)
+	set.RDate(time.Date(2018, 1, 2, 12, 0, 0, 0, time.UTC))
+	set.ExDate(time.Date(2018, 1, 5, 9, 0, 0, 0, time.UTC))
+	value := set.DebugString()
+	for _, want := range []string{
+		"DTSTART: 2018-01-01 09:00:00 +0000 UTC\n",
+		"RRULE 0: FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,-1FR\n  Freq: WEEKLY\n  Interval: 2\n  Byweekday: [MO -1FR]\n",
+		"EXRULE 0: FREQ=DAILY;COUNT=1\n  Freq: DAILY\n  Count: 1\n",
+		"RDATE: 2018-01-02 12:00:00 +0000 UTC\nEXDATE: 2018-01-05 09:00:00 +0000 UTC\n",
+		"First 5 occurrences:\n  2018-01-02 12:00:00 +0000 UTC\n  2018-01-15 09:00:00 +0000 UTC\n",
+	} {
+		if !strings.Contains(value, want) {
+			t.Errorf("get %s, want it to contain %s", value, want)
+		}
+	}
+	if value, want := (&Set{}).DebugString(), "DTSTART: none\nFirst 5 occurrences: none"; value != want {
+		t.Errorf("get %q, want %q", value, want)
+	}
+}
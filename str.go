@@ -3,8 +3,10 @@ package rrule
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,15 +19,37 @@ const (
 	DateFormat = "20060102"
 )
 
+// ParseMode selects which iCalendar RFC the parsers follow where
+// RFC 2445 and RFC 5545 differ.
+type ParseMode int
+
+const (
+	// RFC5545 parses times without TZID and without "Z" suffix in UTC.
+	RFC5545 ParseMode = iota
+	// RFC2445 parses times without TZID and without "Z" suffix as floating
+	// times, in the local time zone. EXRULE and BYDAY values without a sign,
+	// e.g. "2MO", are accepted in both modes.
+	RFC2445
+)
+
+func (mode ParseMode) defaultLocation() *time.Location {
+	if mode == RFC2445 {
+		return time.Local
+	}
+	return time.UTC
+}
+
 func timeToStr(time time.Time) string {
 	return time.UTC().Format(DateTimeFormat)
 }
 
+// timeToDtStartStr formats time as the parameters and value of DTSTART,
+// with a TZID if it is in a named time zone and in UTC otherwise.
 func timeToDtStartStr(time time.Time) string {
-	if time.Location().String() != "UTC" {
+	if hasNamedZone(time) {
 		return fmt.Sprintf(";TZID=%s:%s", time.Location().String(), time.Format(LocalDateTimeFormat))
 	}
-	return fmt.Sprintf(":%s", time.Format(DateTimeFormat))
+	return fmt.Sprintf(":%s", timeToStr(time))
 }
 
 func strToTimeInLoc(str string, loc *time.Location) (time.Time, error) {
@@ -57,27 +81,69 @@ func strToFreq(str string) (Frequency, error) {
 	return result, nil
 }
 
+var defaultWeekdayNames = []string{"MO", "TU", "WE", "TH", "FR", "SA", "SU"}
+
 func (wday Weekday) String() string {
-	s := [...]string{"MO", "TU", "WE", "TH", "FR", "SA", "SU"}[wday.weekday]
+	return wday.format(nil)
+}
+
+// format is same as String but uses names as weekday abbreviations,
+// or the RFC 5545 ones if names is nil.
+func (wday Weekday) format(names []string) string {
+	if names == nil {
+		names = defaultWeekdayNames
+	}
+	s := names[wday.weekday]
 	if wday.n == 0 {
 		return s
 	}
 	return fmt.Sprintf("%+d%s", wday.n, s)
 }
 
+// checkWeekdayNames returns an error if names can not be used as weekday
+// abbreviations in rule strings.
+func checkWeekdayNames(names []string) error {
+	if names == nil {
+		return nil
+	}
+	if len(names) != 7 {
+		return errors.New("WeekdayNames must have 7 names")
+	}
+	for i, name := range names {
+		if name == "" || strings.ContainsAny(name, ",;=:+-0123456789 \t\r\n") {
+			return fmt.Errorf("bad weekday name: %q", name)
+		}
+		for _, other := range names[:i] {
+			if name == other {
+				return fmt.Errorf("duplicated weekday name: %q", name)
+			}
+		}
+	}
+	return nil
+}
+
 func strToWeekday(str string) (Weekday, error) {
-	if len(str) < 2 {
-		return Weekday{}, errors.New("undefined weekday: " + str)
+	return strToWeekdayWithNames(str, nil)
+}
+
+// strToWeekdayWithNames is same as strToWeekday but parses the weekday
+// abbreviations in names, or the RFC 5545 ones if names is nil.
+func strToWeekdayWithNames(str string, names []string) (Weekday, error) {
+	if names == nil {
+		names = defaultWeekdayNames
 	}
-	weekMap := map[string]Weekday{
-		"MO": MO, "TU": TU, "WE": WE, "TH": TH,
-		"FR": FR, "SA": SA, "SU": SU}
-	result, ok := weekMap[str[len(str)-2:]]
-	if !ok {
+	var result Weekday
+	name := ""
+	for i, s := range names {
+		if strings.HasSuffix(str, s) && len(s) > len(name) {
+			result, name = weekdays[i], s
+		}
+	}
+	if name == "" {
 		return Weekday{}, errors.New("undefined weekday: " + str)
 	}
-	if len(str) > 2 {
-		n, e := strconv.Atoi(str[:len(str)-2])
+	if len(str) > len(name) {
+		n, e := strconv.Atoi(str[:len(str)-len(name)])
 		if e != nil {
 			return Weekday{}, e
 		}
@@ -86,12 +152,12 @@ func strToWeekday(str string) (Weekday, error) {
 	return result, nil
 }
 
-func strToWeekdays(value string) ([]Weekday, error) {
+func strToWeekdays(value string, names []string) ([]Weekday, error) {
 	contents := strings.Split(value, ",")
 	result := make([]Weekday, len(contents))
 	var e error
 	for i, s := range contents {
-		result[i], e = strToWeekday(s)
+		result[i], e = strToWeekdayWithNames(s, names)
 		if e != nil {
 			return nil, e
 		}
@@ -110,6 +176,52 @@ func appendIntsOption(options []string, key string, value []int) []string {
 	return append(options, fmt.Sprintf("%s=%s", key, strings.Join(valueStr, ",")))
 }
 
+// canonicalPositions returns a sorted copy of positions, with positive
+// positions in ascending order followed by negative ones in descending order,
+// as in 1,3,-1,-2.
+func canonicalPositions(positions []int) []int {
+	result := copyInts(positions)
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if (a > 0) != (b > 0) {
+			return a > 0
+		}
+		if a > 0 {
+			return a < b
+		}
+		return a > b
+	})
+	return result
+}
+
+// canonicalWeekdays returns a sorted copy of days, with plain weekdays
+// first, then positive nth weekdays in ascending order of n, then negative
+// ones in descending order of |n|, as in FR,MO,+1MO,+2FR,-2MO,-1FR. Days
+// with the same n are sorted alphabetically by their RFC 5545 abbreviation.
+func canonicalWeekdays(days []Weekday) []Weekday {
+	result := append([]Weekday(nil), days...)
+	rank := func(n int) int {
+		switch {
+		case n == 0:
+			return 0
+		case n > 0:
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if rank(a.n) != rank(b.n) {
+			return rank(a.n) < rank(b.n)
+		}
+		if a.n != b.n {
+			return a.n < b.n
+		}
+		return a.String() < b.String()
+	})
+	return result
+}
+
 func strToInts(value string) ([]int, error) {
 	contents := strings.Split(value, ",")
 	result := make([]int, len(contents))
@@ -123,42 +235,80 @@ func strToInts(value string) ([]int, error) {
 	return result, nil
 }
 
+// String returns the rule parts of the option, with the RFC 5545 weekday
+// abbreviations whatever its WeekdayNames.
 func (option *ROption) String() string {
+	return option.format(nil)
+}
+
+// StringWithWeekdayNames is same as String but writes the weekday
+// abbreviations of WeekdayNames, as parsed by StrToROptionWithWeekdayNames.
+// The result is not valid iCalendar unless WeekdayNames is nil.
+func (option *ROption) StringWithWeekdayNames() string {
+	return option.format(option.WeekdayNames)
+}
+
+// format is same as String but uses names as weekday abbreviations, or the
+// RFC 5545 ones if names is nil.
+func (option *ROption) format(names []string) string {
 	result := []string{fmt.Sprintf("FREQ=%v", option.Freq)}
+	if option.RScale != "" {
+		result = append(result, fmt.Sprintf("RSCALE=%s", option.RScale))
+	}
 	if !option.Dtstart.IsZero() && !option.RFC {
 		result = append(result, fmt.Sprintf("DTSTART=%s", timeToStr(option.Dtstart)))
 	}
-	if option.Interval != 0 {
+	// INTERVAL defaults to 1.
+	if option.Interval != 0 && option.Interval != 1 {
 		result = append(result, fmt.Sprintf("INTERVAL=%v", option.Interval))
 	}
 	if option.Wkst != MO {
-		result = append(result, fmt.Sprintf("WKST=%v", option.Wkst))
+		result = append(result, fmt.Sprintf("WKST=%v", option.Wkst.format(names)))
 	}
-	if option.Count != 0 {
+	if option.Count != 0 || option.CountSet {
 		result = append(result, fmt.Sprintf("COUNT=%v", option.Count))
 	}
 	if !option.Until.IsZero() {
 		result = append(result, fmt.Sprintf("UNTIL=%v", timeToStr(option.Until)))
 	}
-	result = appendIntsOption(result, "BYSETPOS", option.Bysetpos)
+	result = appendIntsOption(result, "BYSETPOS", canonicalPositions(option.Bysetpos))
 	result = appendIntsOption(result, "BYMONTH", option.Bymonth)
 	result = appendIntsOption(result, "BYMONTHDAY", option.Bymonthday)
 	result = appendIntsOption(result, "BYYEARDAY", option.Byyearday)
 	result = appendIntsOption(result, "BYWEEKNO", option.Byweekno)
 	if len(option.Byweekday) != 0 {
 		valueStr := make([]string, len(option.Byweekday))
-		for i, wday := range option.Byweekday {
-			valueStr[i] = wday.String()
+		for i, wday := range canonicalWeekdays(option.Byweekday) {
+			valueStr[i] = wday.format(names)
 		}
 		result = append(result, fmt.Sprintf("BYDAY=%s", strings.Join(valueStr, ",")))
 	}
 	result = appendIntsOption(result, "BYHOUR", option.Byhour)
 	result = appendIntsOption(result, "BYMINUTE", option.Byminute)
 	result = appendIntsOption(result, "BYSECOND", option.Bysecond)
+	// BYEASTER is not part of RFC 5545, but it is emitted in RFC mode as
+	// well so that the rule is not silently changed.
 	result = appendIntsOption(result, "BYEASTER", option.Byeaster)
 	return strings.Join(result, ";")
 }
 
+// MarshalText implements encoding.TextMarshaler, encoding the option as
+// returned by String.
+func (option ROption) MarshalText() ([]byte, error) {
+	return []byte(option.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, decoding a string
+// accepted by NewRRuleFromString.
+func (option *ROption) UnmarshalText(text []byte) error {
+	r, err := NewRRuleFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*option = r.OrigOptions
+	return nil
+}
+
 // StrToROption converts string to ROption
 func StrToROption(rfcString string) (*ROption, error) {
 	return StrToROptionInLocation(rfcString, time.UTC)
@@ -168,11 +318,25 @@ func StrToROption(rfcString string) (*ROption, error) {
 // time is supplied as date-time/date field (ex. UNTIL), it is parsed
 // as a time in a given location (time zone)
 func StrToROptionInLocation(rfcString string, loc *time.Location) (*ROption, error) {
+	return strToROption(rfcString, loc, nil)
+}
+
+// StrToROptionWithWeekdayNames is same as StrToROption but parses the
+// weekday abbreviations in names, which are kept in the WeekdayNames of
+// the result.
+func StrToROptionWithWeekdayNames(rfcString string, names []string) (*ROption, error) {
+	if err := checkWeekdayNames(names); err != nil {
+		return nil, err
+	}
+	return strToROption(rfcString, time.UTC, names)
+}
+
+func strToROption(rfcString string, loc *time.Location, names []string) (*ROption, error) {
 	rfcString = strings.TrimSpace(rfcString)
 	if len(rfcString) == 0 {
 		return nil, errors.New("empty string")
 	}
-	result := ROption{}
+	result := ROption{WeekdayNames: names}
 	result.RFC = true
 	freqSet := false
 	for _, attr := range strings.Split(rfcString, ";") {
@@ -195,9 +359,10 @@ func StrToROptionInLocation(rfcString string, loc *time.Location) (*ROption, err
 		case "INTERVAL":
 			result.Interval, e = strconv.Atoi(value)
 		case "WKST":
-			result.Wkst, e = strToWeekday(value)
+			result.Wkst, e = strToWeekdayWithNames(value, names)
 		case "COUNT":
 			result.Count, e = strconv.Atoi(value)
+			result.CountSet = true
 		case "UNTIL":
 			result.Until, e = strToTimeInLoc(value, loc)
 		case "BYSETPOS":
@@ -211,7 +376,7 @@ func StrToROptionInLocation(rfcString string, loc *time.Location) (*ROption, err
 		case "BYWEEKNO":
 			result.Byweekno, e = strToInts(value)
 		case "BYDAY":
-			result.Byweekday, e = strToWeekdays(value)
+			result.Byweekday, e = strToWeekdays(value, names)
 		case "BYHOUR":
 			result.Byhour, e = strToInts(value)
 		case "BYMINUTE":
@@ -220,6 +385,8 @@ func StrToROptionInLocation(rfcString string, loc *time.Location) (*ROption, err
 			result.Bysecond, e = strToInts(value)
 		case "BYEASTER":
 			result.Byeaster, e = strToInts(value)
+		case "RSCALE":
+			result.RScale = value
 		default:
 			return nil, errors.New("unknown RRULE property: " + key)
 		}
@@ -237,8 +404,89 @@ func StrToROptionInLocation(rfcString string, loc *time.Location) (*ROption, err
 	return &result, nil
 }
 
+// String returns the rule as written by ROption.String, except that in
+// non-RFC mode a DTSTART in a named time zone is emitted on its own line,
+// as in "DTSTART;TZID=America/New_York:20180101T090000\nRRULE:FREQ=MONTHLY",
+// so that the time zone survives a round trip through NewRRuleFromString.
+// The result is not a single rule value in that case; the String method of
+// OrigOptions always returns one.
 func (r *RRule) String() string {
-	return r.OrigOptions.String()
+	option := r.OrigOptions
+	if !option.RFC && hasNamedZone(option.Dtstart) {
+		dtstart := option.Dtstart
+		option.RFC = true
+		return fmt.Sprintf("DTSTART%s\nRRULE:%s", timeToDtStartStr(dtstart), &option)
+	}
+	return option.String()
+}
+
+// StringWithWeekdayNames is same as String but writes the weekday
+// abbreviations of the WeekdayNames of the rule, see
+// ROption.StringWithWeekdayNames.
+func (r *RRule) StringWithWeekdayNames() string {
+	return r.OrigOptions.StringWithWeekdayNames()
+}
+
+// ToRFC5545 returns the rule as an RFC 5545 RRULE value. Unlike String it
+// never includes DTSTART, omits defaulted rule parts and orders the rule
+// parts as in the grammar of RFC 5545 section 3.3.10. It returns an error
+// if the rule uses extensions such as BYEASTER.
+func (r *RRule) ToRFC5545() (string, error) {
+	return optionToRFC5545(r.OrigOptions)
+}
+
+// optionToRFC5545 is same as RRule.ToRFC5545 for the options of a rule.
+func optionToRFC5545(option ROption) (string, error) {
+	if len(option.Byeaster) != 0 {
+		return "", errors.New("BYEASTER is not part of RFC 5545")
+	}
+	result := []string{fmt.Sprintf("FREQ=%v", option.Freq)}
+	if !option.Until.IsZero() {
+		result = append(result, fmt.Sprintf("UNTIL=%v", timeToStr(option.Until)))
+	}
+	if option.Count != 0 || option.CountSet {
+		result = append(result, fmt.Sprintf("COUNT=%v", option.Count))
+	}
+	if option.Interval > 1 {
+		result = append(result, fmt.Sprintf("INTERVAL=%v", option.Interval))
+	}
+	result = appendIntsOption(result, "BYSECOND", option.Bysecond)
+	result = appendIntsOption(result, "BYMINUTE", option.Byminute)
+	result = appendIntsOption(result, "BYHOUR", option.Byhour)
+	if len(option.Byweekday) != 0 {
+		valueStr := make([]string, len(option.Byweekday))
+		for i, wday := range canonicalWeekdays(option.Byweekday) {
+			valueStr[i] = wday.String()
+		}
+		result = append(result, fmt.Sprintf("BYDAY=%s", strings.Join(valueStr, ",")))
+	}
+	result = appendIntsOption(result, "BYMONTHDAY", option.Bymonthday)
+	result = appendIntsOption(result, "BYYEARDAY", option.Byyearday)
+	result = appendIntsOption(result, "BYWEEKNO", option.Byweekno)
+	result = appendIntsOption(result, "BYMONTH", option.Bymonth)
+	result = appendIntsOption(result, "BYSETPOS", canonicalPositions(option.Bysetpos))
+	if option.Wkst != MO {
+		result = append(result, fmt.Sprintf("WKST=%v", option.Wkst))
+	}
+	return strings.Join(result, ";"), nil
+}
+
+// namedZones caches whether location names are loadable, as loading a
+// location reads the time zone database.
+var namedZones sync.Map
+
+// hasNamedZone reports whether t is in a non-UTC location loadable by name.
+func hasNamedZone(t time.Time) bool {
+	name := t.Location().String()
+	if t.IsZero() || name == "UTC" || name == "Local" {
+		return false
+	}
+	if loadable, ok := namedZones.Load(name); ok {
+		return loadable.(bool)
+	}
+	_, err := time.LoadLocation(name)
+	namedZones.Store(name, err == nil)
+	return err == nil
 }
 
 func (set *Set) String() string {
@@ -246,49 +494,424 @@ func (set *Set) String() string {
 	return strings.Join(res, "\n")
 }
 
-// StrToRRule converts string to RRule
+// debugOccurrences is the number of occurrences shown by DebugString.
+const debugOccurrences = 5
+
+// DebugString returns a human readable, multi-line description of the set
+// for debugging: its DTSTART, the options of its rules, its dates and its
+// first occurrences. The format is not meant to be parsed.
+func (set *Set) DebugString() string {
+	var b strings.Builder
+	if set.dtstart.IsZero() {
+		b.WriteString("DTSTART: none\n")
+	} else {
+		fmt.Fprintf(&b, "DTSTART: %v\n", set.dtstart)
+	}
+	for _, group := range []struct {
+		name  string
+		rules []*RRule
+	}{{"RRULE", set.rrule}, {"EXRULE", set.exrule}} {
+		for i, r := range group.rules {
+			fmt.Fprintf(&b, "%s %d: %v\n", group.name, i, &r.OrigOptions)
+			for _, field := range optionFields(r.OrigOptions) {
+				fmt.Fprintf(&b, "  %s\n", field)
+			}
+		}
+	}
+	for _, group := range []struct {
+		name  string
+		times []time.Time
+	}{{"RDATE", set.rdate}, {"EXDATE", set.exdate}} {
+		for _, t := range group.times {
+			fmt.Fprintf(&b, "%s: %v\n", group.name, t)
+		}
+	}
+	occurrences := all(truncateIterator(set.Iterator(), debugOccurrences))
+	fmt.Fprintf(&b, "First %d occurrences:", debugOccurrences)
+	if len(occurrences) == 0 {
+		b.WriteString(" none")
+	}
+	for _, t := range occurrences {
+		fmt.Fprintf(&b, "\n  %v", t)
+	}
+	return b.String()
+}
+
+// optionFields returns the fields of option which are set, as
+// "Name: value".
+func optionFields(option ROption) []string {
+	fields := []string{fmt.Sprintf("Freq: %v", option.Freq)}
+	add := func(name string, value interface{}, set bool) {
+		if set {
+			fields = append(fields, fmt.Sprintf("%s: %v", name, value))
+		}
+	}
+	add("Dtstart", option.Dtstart, !option.Dtstart.IsZero())
+	add("Interval", option.Interval, option.Interval != 0)
+	add("Wkst", option.Wkst, option.Wkst != MO)
+	add("Count", option.Count, option.Count != 0 || option.CountSet)
+	add("Until", option.Until, !option.Until.IsZero())
+	for _, ints := range []struct {
+		name   string
+		values []int
+	}{
+		{"Bysetpos", option.Bysetpos}, {"Bymonth", option.Bymonth}, {"Bymonthday", option.Bymonthday},
+		{"Byyearday", option.Byyearday}, {"Byweekno", option.Byweekno},
+	} {
+		add(ints.name, ints.values, len(ints.values) != 0)
+	}
+	add("Byweekday", option.Byweekday, len(option.Byweekday) != 0)
+	for _, ints := range []struct {
+		name   string
+		values []int
+	}{
+		{"Byhour", option.Byhour}, {"Byminute", option.Byminute}, {"Bysecond", option.Bysecond},
+		{"Byeaster", option.Byeaster},
+	} {
+		add(ints.name, ints.values, len(ints.values) != 0)
+	}
+	add("RFC", option.RFC, option.RFC)
+	add("RScale", option.RScale, option.RScale != "")
+	return fields
+}
+
+// NewRRuleFromString converts string to RRule.
+// Besides a plain rule, it accepts a DTSTART line followed by an RRULE line
+// as produced by RRule.String for time zone qualified DTSTART.
+func NewRRuleFromString(s string) (*RRule, error) {
+	return strToRRuleInLoc(s, time.UTC)
+}
+
+// StrToRRule converts string to RRule.
+//
+// Deprecated: Use NewRRuleFromString instead.
 func StrToRRule(rfcString string) (*RRule, error) {
-	option, e := StrToROption(rfcString)
+	return NewRRuleFromString(rfcString)
+}
+
+// StrToRRuleInMode is same as NewRRuleFromString but parses according to mode.
+func StrToRRuleInMode(rfcString string, mode ParseMode) (*RRule, error) {
+	return strToRRuleInLoc(rfcString, mode.defaultLocation())
+}
+
+// StrToRRuleMulti converts rules, with or without an "RRULE:" prefix, to
+// RRules starting at dtstart, unless it is zero. The error for an invalid
+// rule reports its index.
+func StrToRRuleMulti(lines []string, dtstart time.Time) ([]*RRule, error) {
+	return StrToRRuleMultiInLoc(lines, dtstart, time.UTC)
+}
+
+// StrToRRuleMultiInLoc is same as StrToRRuleMulti but parses times without
+// TZID and without "Z" suffix in loc.
+func StrToRRuleMultiInLoc(lines []string, dtstart time.Time, loc *time.Location) ([]*RRule, error) {
+	rules := make([]*RRule, len(lines))
+	for i, line := range lines {
+		rule := strings.TrimSpace(line)
+		if strings.HasPrefix(strings.ToUpper(rule), "RRULE:") {
+			rule = rule[len("RRULE:"):]
+		}
+		option, err := StrToROptionInLocation(rule, loc)
+		if err == nil {
+			if !dtstart.IsZero() {
+				option.Dtstart = dtstart
+			}
+			rules[i], err = NewRRule(*option)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d %q: %v", i, line, err)
+		}
+	}
+	return rules, nil
+}
+
+// StrToRRuleWithWeekdayNames is same as NewRRuleFromString but parses the weekday
+// abbreviations in names, see ROption.WeekdayNames.
+func StrToRRuleWithWeekdayNames(rfcString string, names []string) (*RRule, error) {
+	option, e := StrToROptionWithWeekdayNames(rfcString, names)
 	if e != nil {
 		return nil, e
 	}
 	return NewRRule(*option)
 }
 
+func strToRRuleInLoc(rfcString string, loc *time.Location) (*RRule, error) {
+	rfcString = strings.TrimSpace(rfcString)
+	upper := strings.ToUpper(rfcString)
+	if strings.HasPrefix(upper, "DTSTART:") || strings.HasPrefix(upper, "DTSTART;") {
+		return strToRRuleWithDtStart(rfcString, loc)
+	}
+	option, e := StrToROptionInLocation(rfcString, loc)
+	if e != nil {
+		return nil, e
+	}
+	return NewRRule(*option)
+}
+
+func strToRRuleWithDtStart(s string, loc *time.Location) (*RRule, error) {
+	lines := strings.Split(s, "\n")
+	if len(lines) != 2 {
+		return nil, errors.New("wrong format")
+	}
+	dt, err := strToDtStart(strings.TrimSpace(lines[0])[len("DTSTART")+1:], loc)
+	if err != nil {
+		return nil, fmt.Errorf("strToDtStart failed: %v", err)
+	}
+	name, err := processRRuleName(lines[1])
+	if err != nil {
+		return nil, err
+	}
+	if name != "RRULE" {
+		return nil, fmt.Errorf("unsupported property: %v", name)
+	}
+	option, err := StrToROptionInLocation(strings.TrimSpace(lines[1])[len(name)+1:], dt.Location())
+	if err != nil {
+		return nil, err
+	}
+	option.Dtstart = dt
+	option.RFC = false
+	return NewRRule(*option)
+}
+
+// NewSetFromString converts string to RRuleSet
+func NewSetFromString(s string) (*Set, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("empty string")
+	}
+	ss := strings.Split(s, "\n")
+	return NewSetFromSlice(ss)
+}
+
 // StrToRRuleSet converts string to RRuleSet
+//
+// Deprecated: Use NewSetFromString instead.
 func StrToRRuleSet(s string) (*Set, error) {
+	return NewSetFromString(s)
+}
+
+// StrToRRuleSetInMode is same as NewSetFromString but parses according to mode.
+func StrToRRuleSetInMode(s string, mode ParseMode) (*Set, error) {
 	s = strings.TrimSpace(s)
 	if s == "" {
 		return nil, errors.New("empty string")
 	}
-	ss := strings.Split(s, "\n")
-	return StrSliceToRRuleSet(ss)
+	options := DefaultParseOptions()
+	options.Location = mode.defaultLocation()
+	return ParseRRuleSet(strings.Split(s, "\n"), options)
 }
 
-// StrSliceToRRuleSet converts given str slice to RRuleSet
+// StrToRRuleSetStrict is same as NewSetFromString but rejects input which does
+// not conform to RFC 5545: EXRULE, BYEASTER, DTSTART inside RRULE, unknown
+// properties, repeated DTSTART, COUNT together with UNTIL and TZIDs not
+// found in the IANA time zone database.
+func StrToRRuleSetStrict(s string) (*Set, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("empty string")
+	}
+	return ParseRRuleSet(strings.Split(s, "\n"), ParseOptions{Strict: true})
+}
+
+// ParseOptions configures ParseRRuleSet.
+type ParseOptions struct {
+	// Location is used for times without TZID and without "Z" suffix
+	// when there is no DTSTART with a TZID. UTC is used if it is nil.
+	Location *time.Location
+	// Strict rejects input which does not conform to RFC 5545: DTSTART
+	// inside RRULE, unknown properties, repeated DTSTART, COUNT together
+	// with UNTIL and TZIDs not found in the IANA time zone database.
+	Strict bool
+	// AllowEXRULE accepts EXRULE, which is deprecated by RFC 5545.
+	AllowEXRULE bool
+	// AllowByEaster accepts the BYEASTER extension.
+	AllowByEaster bool
+	// MaxOccurrences truncates the set to its first MaxOccurrences
+	// occurrences if positive, see Set.Truncate.
+	MaxOccurrences int
+}
+
+// DefaultParseOptions returns the options NewSetFromSlice parses with.
+func DefaultParseOptions() ParseOptions {
+	return ParseOptions{Location: time.UTC, AllowEXRULE: true, AllowByEaster: true}
+}
+
+// ParseRRuleSet converts lines to a Set as configured by options.
+// NewSetFromSlice, StrSliceToRRuleSetInLoc, StrToRRuleSetInMode and
+// StrToRRuleSetStrict are shorthands for it.
+func ParseRRuleSet(lines []string, options ParseOptions) (*Set, error) {
+	loc := options.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	if options.Strict {
+		if err := checkStrict(lines); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkExtensions(lines, options); err != nil {
+		return nil, err
+	}
+	set, err := parseSet(lines, loc)
+	if err != nil {
+		return nil, err
+	}
+	if options.MaxOccurrences > 0 {
+		set = set.Truncate(options.MaxOccurrences)
+	}
+	return set, nil
+}
+
+// checkStrict returns an error describing the first violation of RFC 5545
+// found in ss, except for the extensions checked by checkExtensions.
+func checkStrict(ss []string) error {
+	dtstarts := 0
+	for _, line := range ss {
+		name, err := processRRuleName(line)
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch name {
+		case "DTSTART":
+			if dtstarts++; dtstarts > 1 {
+				return errors.New("DTSTART must not occur more than once")
+			}
+		case "RDATE", "EXDATE":
+		case "RRULE", "EXRULE":
+			parts := ruleParts(line[len(name)+1:])
+			if parts["DTSTART"] {
+				return errors.New("DTSTART is not a rule part of RFC 5545")
+			}
+			if parts["COUNT"] && parts["UNTIL"] {
+				return errors.New("COUNT and UNTIL must not occur in the same rule")
+			}
+			continue
+		default:
+			return fmt.Errorf("unsupported property: %v", name)
+		}
+		end := strings.Index(line, ":")
+		if end < 0 {
+			return fmt.Errorf("bad format %v", line)
+		}
+		for _, param := range strings.Split(line[len(name):end], ";") {
+			if !strings.HasPrefix(param, "TZID=") {
+				continue
+			}
+			if _, err := parseTZID(param); err != nil {
+				return fmt.Errorf("unknown %s: %v", param, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkExtensions returns an error if ss uses EXRULE or BYEASTER while
+// options do not allow it.
+func checkExtensions(ss []string, options ParseOptions) error {
+	for _, line := range ss {
+		name, err := processRRuleName(line)
+		if err != nil {
+			return err
+		}
+		if name != "RRULE" && name != "EXRULE" {
+			continue
+		}
+		if name == "EXRULE" && !options.AllowEXRULE {
+			return errors.New("EXRULE is deprecated by RFC 5545")
+		}
+		if !options.AllowByEaster && ruleParts(strings.TrimSpace(line)[len(name)+1:])["BYEASTER"] {
+			return errors.New("BYEASTER is not a rule part of RFC 5545")
+		}
+	}
+	return nil
+}
+
+// ruleValues returns the values of the rule parts of a rule by name.
+// Parts without a value are ignored.
+func ruleValues(rule string) map[string]string {
+	values := map[string]string{}
+	for _, attr := range strings.Split(rule, ";") {
+		if keyValue := strings.SplitN(attr, "=", 2); len(keyValue) == 2 {
+			values[strings.ToUpper(keyValue[0])] = keyValue[1]
+		}
+	}
+	return values
+}
+
+// ruleParts returns the set of rule part names of a rule.
+func ruleParts(rule string) map[string]bool {
+	parts := map[string]bool{}
+	for _, attr := range strings.Split(rule, ";") {
+		parts[strings.ToUpper(strings.SplitN(attr, "=", 2)[0])] = true
+	}
+	return parts
+}
+
+// NewSetFromSlice converts given str slice to RRuleSet
 // In case there is a time met in any rule without specified time zone, when
 // it is parsed in UTC (see StrSliceToRRuleSetInLoc)
+// RRULE and EXRULE lines are added to the set in the order they appear,
+// so it preserves insertion order.
+func NewSetFromSlice(lines []string) (*Set, error) {
+	return ParseRRuleSet(lines, DefaultParseOptions())
+}
+
+// StrSliceToRRuleSet converts given str slice to RRuleSet
+//
+// Deprecated: Use NewSetFromSlice instead.
 func StrSliceToRRuleSet(ss []string) (*Set, error) {
-	return StrSliceToRRuleSetInLoc(ss, time.UTC)
+	return NewSetFromSlice(ss)
 }
 
-// StrSliceToRRuleSetInLoc is same as StrSliceToRRuleSet, but by default parses local times
+// StrSliceToRRuleSetInLoc is same as NewSetFromSlice, but by default parses local times
 // in specified default location
 func StrSliceToRRuleSetInLoc(ss []string, defaultLoc *time.Location) (*Set, error) {
-	if len(ss) == 0 {
+	options := DefaultParseOptions()
+	options.Location = defaultLoc
+	return ParseRRuleSet(ss, options)
+}
+
+// parseSet converts ss to a Set, parsing local times in defaultLoc unless
+// DTSTART has a TZID.
+func parseSet(ss []string, defaultLoc *time.Location) (*Set, error) {
+	zones, ss, err := extractVTimezones(ss)
+	if err != nil {
+		return nil, err
+	}
+	return parseSetInZones(ss, defaultLoc, zones)
+}
+
+// parseSetInZones is same as parseSet but resolves TZID parameters in zones
+// before the IANA time zone database.
+func parseSetInZones(ss []string, defaultLoc *time.Location, zones map[string]*time.Location) (*Set, error) {
+	merged, ss, err := extractMergedSets(ss)
+	if err != nil {
+		return nil, err
+	}
+	if len(ss) == 0 && len(merged) == 0 {
 		return &Set{}, nil
 	}
 
 	set := Set{}
 
-	// According to RFC DTSTART is always the first line.
-	firstName, err := processRRuleName(ss[0])
-	if err != nil {
-		return nil, err
+	// DTSTART usually is the first line, but some generators emit it after
+	// the rules, so it is looked up before parsing the other lines.
+	dtstartIndex := -1
+	for i, line := range ss {
+		name, err := processRRuleName(line)
+		if err != nil {
+			return nil, err
+		}
+		if name == "DTSTART" {
+			if dtstartIndex != -1 {
+				return nil, errors.New("DTSTART must not occur more than once")
+			}
+			dtstartIndex = i
+		}
 	}
 
-	if firstName == "DTSTART" {
-		dt, err := strToDtStart(ss[0][len(firstName)+1:], defaultLoc)
+	if dtstartIndex != -1 {
+		dt, err := strToDtStartInZones(strings.TrimSpace(ss[dtstartIndex])[len("DTSTART")+1:], defaultLoc, zones)
 		if err != nil {
 			return nil, fmt.Errorf("strToDtStart failed: %v", err)
 		}
@@ -296,8 +919,8 @@ func StrSliceToRRuleSetInLoc(ss []string, defaultLoc *time.Location) (*Set, erro
 		// parse local times met in RDATE,EXDATE and other rules
 		defaultLoc = dt.Location()
 		set.DTStart(dt)
-		// We've processed the first one
-		ss = ss[1:]
+		// We've processed DTSTART
+		ss = append(append([]string{}, ss[:dtstartIndex]...), ss[dtstartIndex+1:]...)
 	}
 
 	for _, line := range ss {
@@ -327,7 +950,7 @@ func StrSliceToRRuleSetInLoc(ss []string, defaultLoc *time.Location) (*Set, erro
 				set.ExRule(r)
 			}
 		case "RDATE", "EXDATE":
-			ts, err := StrToDatesInLoc(rule, defaultLoc)
+			ts, err := strToDatesInZones(rule, defaultLoc, zones)
 			if err != nil {
 				return nil, fmt.Errorf("strToDates failed: %v", err)
 			}
@@ -338,14 +961,82 @@ func StrSliceToRRuleSetInLoc(ss []string, defaultLoc *time.Location) (*Set, erro
 					set.ExDate(t)
 				}
 			}
+		case "X-RRULE-SOURCE":
+			set.sources = append(set.sources, rule)
+		case "X-RRULE-EXRANGE":
+			bounds := strings.Split(rule, "/")
+			if len(bounds) != 2 {
+				return nil, fmt.Errorf("bad %s: %q", name, rule)
+			}
+			after, err := strToTimeInLoc(bounds[0], defaultLoc)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s: %v", name, err)
+			}
+			before, err := strToTimeInLoc(bounds[1], defaultLoc)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s: %v", name, err)
+			}
+			set.AddExcludeRange(after, before)
+		case "X-RRULE-SKIP", "X-RRULE-LIMIT":
+			n, err := strconv.Atoi(rule)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("bad %s: %q", name, rule)
+			}
+			if name == "X-RRULE-SKIP" {
+				set.skip = n
+			} else {
+				set.limit, set.truncated = n, true
+			}
 		default:
 			return nil, fmt.Errorf("unsupported property: %v", name)
 		}
 	}
 
+	for _, lines := range merged {
+		m, err := parseSetInZones(lines, defaultLoc, zones)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %v", mergedSetComponent, err)
+		}
+		set.merged = append(set.merged, m)
+	}
+
 	return &set, nil
 }
 
+// extractMergedSets returns the lines of each outermost component written
+// by Set.Recurrence for a set combined by Merge, without its BEGIN and END
+// lines, and the remaining lines.
+func extractMergedSets(ss []string) ([][]string, []string, error) {
+	var merged [][]string
+	var rest []string
+	depth, start := 0, 0
+	for i, line := range ss {
+		switch strings.ToUpper(strings.TrimSpace(line)) {
+		case "BEGIN:" + mergedSetComponent:
+			if depth == 0 {
+				start = i + 1
+			}
+			depth++
+		case "END:" + mergedSetComponent:
+			if depth == 0 {
+				return nil, nil, fmt.Errorf("%s is not started", mergedSetComponent)
+			}
+			depth--
+			if depth == 0 {
+				merged = append(merged, ss[start:i])
+			}
+		default:
+			if depth == 0 {
+				rest = append(rest, line)
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, nil, fmt.Errorf("%s is not terminated", mergedSetComponent)
+	}
+	return merged, rest, nil
+}
+
 // StrToDates is intended to parse RDATE and EXDATE properties supporting only
 // VALUE=DATE-TIME (DATE and PERIOD are not supported).
 // Accepts string with format: "VALUE=DATE-TIME;[TZID=...]:{time},{time},...,{time}"
@@ -358,6 +1049,12 @@ func StrToDates(str string) (ts []time.Time, err error) {
 // StrToDatesInLoc same as StrToDates but it consideres default location to parse dates in
 // in case no location specified with TZID parameter
 func StrToDatesInLoc(str string, defaultLoc *time.Location) (ts []time.Time, err error) {
+	return strToDatesInZones(str, defaultLoc, nil)
+}
+
+// strToDatesInZones is same as StrToDatesInLoc but resolves TZID parameters
+// in zones before the IANA time zone database.
+func strToDatesInZones(str string, defaultLoc *time.Location, zones map[string]*time.Location) (ts []time.Time, err error) {
 	tmp := strings.Split(str, ":")
 	if len(tmp) > 2 {
 		return nil, fmt.Errorf("bad format")
@@ -367,7 +1064,7 @@ func StrToDatesInLoc(str string, defaultLoc *time.Location) (ts []time.Time, err
 		params := strings.Split(tmp[0], ";")
 		for _, param := range params {
 			if strings.HasPrefix(param, "TZID=") {
-				loc, err = parseTZID(param)
+				loc, err = parseTZIDInZones(param, zones)
 			} else if param != "VALUE=DATE-TIME" && param != "VALUE=DATE" {
 				err = fmt.Errorf("unsupported: %v", param)
 			}
@@ -400,24 +1097,50 @@ func processRRuleName(line string) (string, error) {
 	}
 
 	name := line[:nameLen]
-	if strings.IndexAny(name, "=") > 0 {
-		return "", fmt.Errorf("bad format %v", line)
+	// Property names are iana-tokens: ALPHA, DIGIT and "-".
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return "", fmt.Errorf("bad format %v", line)
+		}
 	}
 
 	return name, nil
 }
 
+// StrToDtStart parses a DTSTART value with its parameters but without the
+// "DTSTART;" prefix, such as "TZID=America/New_York:19970714T133000",
+// "19970714T173000Z" or "19970714T133000". Times without TZID and without
+// "Z" suffix are parsed in defaultLoc. Strings including the property
+// name, such as "DTSTART:19970714T173000Z", are rejected.
+func StrToDtStart(str string, defaultLoc *time.Location) (time.Time, error) {
+	if strings.HasPrefix(strings.ToUpper(str), "DTSTART") {
+		return time.Time{}, errors.New("DTSTART property name must not be included")
+	}
+	return strToDtStart(str, defaultLoc)
+}
+
 // strToDtStart accepts string with format: "(TZID={timezone}:)?{time}" and parses it to a date
 // may be used to parse DTSTART rules, without the DTSTART; part.
+// A bare UTC property, "DTSTART:{time}Z", is accepted as well.
 func strToDtStart(str string, defaultLoc *time.Location) (time.Time, error) {
+	return strToDtStartInZones(str, defaultLoc, nil)
+}
+
+// strToDtStartInZones is same as strToDtStart but resolves the TZID parameter
+// in zones before the IANA time zone database.
+func strToDtStartInZones(str string, defaultLoc *time.Location, zones map[string]*time.Location) (time.Time, error) {
 	tmp := strings.Split(str, ":")
 	if len(tmp) > 2 || len(tmp) == 0 {
 		return time.Time{}, fmt.Errorf("bad format")
 	}
 
 	if len(tmp) == 2 {
+		if tmp[0] == "DTSTART" && strings.HasSuffix(tmp[1], "Z") {
+			// bare DTSTART property in UTC
+			return strToTimeInLoc(tmp[1], time.UTC)
+		}
 		// tzid
-		loc, err := parseTZID(tmp[0])
+		loc, err := parseTZIDInZones(tmp[0], zones)
 		if err != nil {
 			return time.Time{}, err
 		}
@@ -428,8 +1151,22 @@ func strToDtStart(str string, defaultLoc *time.Location) (time.Time, error) {
 }
 
 func parseTZID(s string) (*time.Location, error) {
+	return parseTZIDInZones(s, nil)
+}
+
+// parseTZIDInZones is same as parseTZID but looks the time zone up in zones,
+// as defined by VTIMEZONE blocks, before the IANA time zone database.
+func parseTZIDInZones(s string, zones map[string]*time.Location) (*time.Location, error) {
 	if !strings.HasPrefix(s, "TZID=") || len(s) == len("TZID=") {
 		return nil, fmt.Errorf("bad TZID parameter format")
 	}
-	return time.LoadLocation(s[len("TZID="):])
+	// Parameter values may be quoted.
+	name := strings.TrimSuffix(strings.TrimPrefix(s[len("TZID="):], `"`), `"`)
+	if name == "" {
+		return nil, fmt.Errorf("bad TZID parameter format")
+	}
+	if loc, ok := zones[name]; ok {
+		return loc, nil
+	}
+	return time.LoadLocation(name)
 }
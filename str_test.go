@@ -1,7 +1,9 @@
 package rrule
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -21,6 +23,27 @@ func TestRuleToStr(t *testing.T) {
 	dtStart := time.Date(2018, 1, 1, 9, 0, 0, 0, nyLoc)
 
 	r, _ := NewRRule(ROption{Freq: MONTHLY, Dtstart: dtStart})
+	want := "DTSTART;TZID=America/New_York:20180101T090000\nRRULE:FREQ=MONTHLY"
+	if r.String() != want {
+		t.Errorf("Expected non RFC string %s, got %v", want, r.String())
+	}
+	// The options of the rule stay a single rule value, in UTC.
+	if want := "FREQ=MONTHLY;DTSTART=20180101T140000Z"; r.OrigOptions.String() != want {
+		t.Errorf("Expected non RFC string %s, got %v", want, r.OrigOptions.String())
+	}
+
+	r, err := StrToRRule(r.String())
+	if err != nil {
+		t.Fatal("expected", nil, "got", err)
+	}
+	if !r.DateStart.Equal(dtStart) || r.DateStart.Location().String() != "America/New_York" {
+		t.Errorf("Expected DTSTART %v, got %v", dtStart, r.DateStart)
+	}
+	if r.String() != want {
+		t.Errorf("Expected non RFC string %s, got %v", want, r.String())
+	}
+
+	r, _ = NewRRule(ROption{Freq: MONTHLY, Dtstart: dtStart.UTC()})
 	if r.String() != "FREQ=MONTHLY;DTSTART=20180101T140000Z" {
 		t.Errorf("Expected non RFC string FREQ=MONTHLY;DTSTART=20180101T140000Z, got %v", r.String())
 	}
@@ -57,6 +80,102 @@ func TestStr(t *testing.T) {
 	}
 }
 
+func TestStrNthWeekdaySign(t *testing.T) {
+	for wday, want := range map[Weekday]string{FR.Nth(2): "+2FR", MO.Nth(-1): "-1MO", SU: "SU"} {
+		if s := wday.String(); s != want {
+			t.Errorf("get %q, want %q", s, want)
+		}
+	}
+	// An unsigned positive nth value is written with a "+" and round-trips.
+	r, _ := StrToRRule("FREQ=MONTHLY;DTSTART=20120201T093000Z;BYDAY=2FR,-1MO")
+	want := "FREQ=MONTHLY;DTSTART=20120201T093000Z;BYDAY=+2FR,-1MO"
+	if s := r.String(); s != want {
+		t.Errorf("get %q, want %q", s, want)
+	}
+	r, _ = StrToRRule(r.String())
+	if s := r.String(); s != want {
+		t.Errorf("get %q, want %q", s, want)
+	}
+}
+
+func TestStrCountZero(t *testing.T) {
+	str := "FREQ=DAILY;DTSTART=20120201T093000Z;COUNT=0"
+	r, _ := NewRRuleFromString(str)
+	if s := r.String(); s != str {
+		t.Errorf("NewRRuleFromString(%q).String() = %q, want %q", str, s, str)
+	}
+	if !r.OrigOptions.CountSet {
+		t.Errorf("NewRRuleFromString(%q).OrigOptions.CountSet = false, want true", str)
+	}
+	if value, want := len(r.Between(r.DateStart, r.DateStart.AddDate(0, 0, 9), true)), 10; value != want {
+		t.Errorf("get %d occurrences, want %d", value, want)
+	}
+
+	r, _ = NewRRule(ROption{Freq: DAILY, Dtstart: r.DateStart})
+	if s := r.String(); strings.Contains(s, "COUNT") {
+		t.Errorf("get %q, want no COUNT", s)
+	}
+}
+
+func TestStrCanonicalByday(t *testing.T) {
+	days := []Weekday{MO.Nth(-1), TU, MO.Nth(2), FR.Nth(-2), MO, FR.Nth(2), SU.Nth(1), MO.Nth(-1)}
+	want := "FREQ=MONTHLY;BYDAY=MO,TU,+1SU,+2FR,+2MO,-2FR,-1MO,-1MO"
+	for i := 0; i < len(days); i++ {
+		rotated := append(append([]Weekday{}, days[i:]...), days[:i]...)
+		option := ROption{Freq: MONTHLY, Byweekday: rotated}
+		if s := option.String(); s != want {
+			t.Errorf("%v: get %q, want %q", rotated, s, want)
+		}
+	}
+	r, _ := NewRRule(ROption{Freq: MONTHLY, Byweekday: days, Dtstart: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if s, _ := r.ToRFC5545(); !strings.HasSuffix(s, "BYDAY=MO,TU,+1SU,+2FR,+2MO,-2FR,-1MO,-1MO") {
+		t.Errorf("get %q", s)
+	}
+}
+
+func TestStrDefaultInterval(t *testing.T) {
+	withInterval, _ := NewRRuleFromString("FREQ=WEEKLY;DTSTART=20120201T093000Z;INTERVAL=1;COUNT=5")
+	without, _ := NewRRuleFromString("FREQ=WEEKLY;DTSTART=20120201T093000Z;COUNT=5")
+	if !timesEqual(withInterval.All(), without.All()) {
+		t.Errorf("get %v, want %v", withInterval.All(), without.All())
+	}
+	if withInterval.String() != without.String() || strings.Contains(withInterval.String(), "INTERVAL") {
+		t.Errorf("get %q and %q, want both without INTERVAL", withInterval, without)
+	}
+}
+
+func TestStrToRRuleMulti(t *testing.T) {
+	dtstart := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	rules, err := StrToRRuleMulti([]string{"FREQ=DAILY;COUNT=2", "rrule:FREQ=WEEKLY;COUNT=2", "RRULE:FREQ=MONTHLY;COUNT=2"}, dtstart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("get %v, want 3 rules", rules)
+	}
+	for i, months := range []int{0, 0, 1} {
+		days := []int{1, 7, 0}[i]
+		want := []time.Time{dtstart, dtstart.AddDate(0, months, days)}
+		if value := rules[i].All(); !timesEqual(value, want) {
+			t.Errorf("%v: get %v, want %v", rules[i], value, want)
+		}
+	}
+
+	_, err = StrToRRuleMulti([]string{"FREQ=DAILY", "FREQ=HELLO"}, dtstart)
+	if err == nil || !strings.Contains(err.Error(), `line 1 "FREQ=HELLO"`) {
+		t.Errorf("get %v, want error for line 1", err)
+	}
+
+	loc := time.FixedZone("UTC+8", 8*3600)
+	rules, err = StrToRRuleMultiInLoc([]string{"FREQ=DAILY;UNTIL=20180102T090000"}, time.Date(2018, 1, 1, 9, 0, 0, 0, loc), loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := rules[0].All(); len(value) != 2 {
+		t.Errorf("get %v, want 2 occurrences", value)
+	}
+}
+
 func TestInvalidString(t *testing.T) {
 	cases := []string{
 		"",
@@ -108,7 +227,6 @@ func TestStrToDtStart(t *testing.T) {
 		"TZID=:20180101T090000",
 		"TZID=notatimezone:20180101T090000",
 		"DTSTART:19970714T133000",
-		"DTSTART:19970714T133000Z",
 		"DTSTART;:19970714T133000Z",
 		"DTSTART;:1997:07:14T13:30:00Z",
 		";:19970714T133000Z",
@@ -120,12 +238,38 @@ func TestStrToDtStart(t *testing.T) {
 		if _, e := strToDtStart(item, time.UTC); e != nil {
 			t.Errorf("strToDtStart(%q) error = %s, want nil", item, e.Error())
 		}
+		if _, e := StrToDtStart(item, time.UTC); e != nil {
+			t.Errorf("StrToDtStart(%q) error = %s, want nil", item, e.Error())
+		}
+	}
+
+	// A bare UTC property is accepted by strToDtStart for the parsers of
+	// this package, but not by the exported StrToDtStart.
+	item := "DTSTART:19970714T133000Z"
+	if _, e := strToDtStart(item, time.UTC); e != nil {
+		t.Errorf("strToDtStart(%q) error = %s, want nil", item, e.Error())
+	}
+	if _, e := StrToDtStart(item, time.UTC); e == nil {
+		t.Errorf("StrToDtStart(%q) err = nil, want not nil", item)
 	}
 
 	for _, item := range invalidCases {
 		if _, e := strToDtStart(item, time.UTC); e == nil {
 			t.Errorf("strToDtStart(%q) err = nil, want not nil", item)
 		}
+		if _, e := StrToDtStart(item, time.UTC); e == nil {
+			t.Errorf("StrToDtStart(%q) err = nil, want not nil", item)
+		}
+	}
+
+	moscow, _ := time.LoadLocation("Europe/Moscow")
+	dt, _ := strToDtStart("DTSTART:19970714T173000Z", moscow)
+	if want := time.Date(1997, 7, 14, 17, 30, 0, 0, time.UTC); dt != want {
+		t.Errorf("get %v, want %v", dt, want)
+	}
+	dt, _ = StrToDtStart("19970714T133000", moscow)
+	if want := time.Date(1997, 7, 14, 13, 30, 0, 0, moscow); dt != want {
+		t.Errorf("get %v, want %v", dt, want)
 	}
 }
 
@@ -321,7 +465,7 @@ func TestSetParseLocalTimes(t *testing.T) {
 			"DTSTART;TZID=Europe/Moscow:20180220T090000",
 			"RDATE;VALUE=DATE-TIME:20180223T100000",
 		}
-		expected := "DTSTART;TZID=Europe/Moscow:20180220T090000\nRDATE:20180223T070000Z"
+		expected := "DTSTART;TZID=Europe/Moscow:20180220T090000\nRDATE;TZID=Europe/Moscow:20180223T100000"
 		s, err := StrSliceToRRuleSet(input)
 		if err != nil {
 			t.Error(err)
@@ -448,3 +592,440 @@ func assertRulesMatch(set *Set, t *testing.T) {
 		t.Errorf("Unexpected exDates: %v", exDates)
 	}
 }
+
+func TestROptionText(t *testing.T) {
+	type event struct {
+		Rule ROption `json:"rule"`
+	}
+	in := event{ROption{Freq: WEEKLY, Count: 3, Byweekday: []Weekday{MO, FR.Nth(-1)},
+		Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)}}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal("expected", nil, "got", err)
+	}
+	want := `{"rule":"FREQ=WEEKLY;DTSTART=19970902T090000Z;COUNT=3;BYDAY=MO,-1FR"}`
+	if string(data) != want {
+		t.Errorf("get %s, want %s", data, want)
+	}
+
+	var out event
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal("expected", nil, "got", err)
+	}
+	if s := out.Rule.String(); s != in.Rule.String() {
+		t.Errorf("get %v, want %v", s, in.Rule.String())
+	}
+	if err := json.Unmarshal([]byte(`{"rule":"FREQ=WEEKLY;BYMONTH=13"}`), &out); err == nil {
+		t.Error("get nil, want error")
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	r, err := StrToRRuleInMode("FREQ=DAILY;DTSTART=20180101T090000;COUNT=1", RFC2445)
+	if err != nil {
+		t.Fatal("expected", nil, "got", err)
+	}
+	if want := time.Date(2018, 1, 1, 9, 0, 0, 0, time.Local); !r.DateStart.Equal(want) {
+		t.Errorf("get %v, want %v", r.DateStart, want)
+	}
+	r, _ = StrToRRuleInMode("FREQ=DAILY;DTSTART=20180101T090000;COUNT=1", RFC5545)
+	if want := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC); !r.DateStart.Equal(want) {
+		t.Errorf("get %v, want %v", r.DateStart, want)
+	}
+
+	set, err := StrToRRuleSetInMode("RRULE:FREQ=MONTHLY;BYDAY=2MO\nRDATE:20180101T090000\nEXRULE:FREQ=YEARLY", RFC2445)
+	if err != nil {
+		t.Fatal("expected", nil, "got", err)
+	}
+	if want := time.Date(2018, 1, 1, 9, 0, 0, 0, time.Local); !set.GetRDate()[0].Equal(want) {
+		t.Errorf("get %v, want %v", set.GetRDate()[0], want)
+	}
+}
+
+func TestDefaultWkstOmitted(t *testing.T) {
+	for _, str := range []string{"FREQ=WEEKLY;BYDAY=MO", "FREQ=WEEKLY;WKST=MO;BYDAY=MO"} {
+		r, err := StrToRRule(str)
+		if err != nil {
+			t.Fatal("expected", nil, "got", err)
+		}
+		want := "FREQ=WEEKLY;BYDAY=MO"
+		if s := r.String(); s != want {
+			t.Errorf("StrToRRule(%q).String() = %q, want %q", str, s, want)
+		}
+		if r2, _ := StrToRRule(r.String()); r2.Wkst != r.Wkst || r2.String() != want {
+			t.Errorf("round trip of %q gave %q", str, r2.String())
+		}
+	}
+	r, _ := StrToRRule("FREQ=WEEKLY;WKST=SU;BYDAY=MO")
+	if s := r.String(); s != "FREQ=WEEKLY;WKST=SU;BYDAY=MO" {
+		t.Errorf("get %q, want WKST=SU kept", s)
+	}
+}
+
+func TestByEasterToStr(t *testing.T) {
+	for _, rfc := range []bool{true, false} {
+		r, _ := NewRRule(ROption{Freq: YEARLY, Byeaster: []int{-2, 1}, RFC: rfc,
+			Dtstart: time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)})
+		if s := r.String(); !strings.HasSuffix(s, ";BYEASTER=-2,1") {
+			t.Errorf("RFC=%v: get %q, want BYEASTER=-2,1", rfc, s)
+		}
+	}
+}
+
+func TestStrToDatesParamOrder(t *testing.T) {
+	nyLoc, _ := time.LoadLocation("America/New_York")
+	want := time.Date(2018, 2, 23, 9, 0, 0, 0, nyLoc)
+	for _, s := range []string{
+		"VALUE=DATE-TIME;TZID=America/New_York:20180223T090000",
+		"TZID=America/New_York;VALUE=DATE-TIME:20180223T090000",
+		`TZID="America/New_York";VALUE=DATE-TIME:20180223T090000`,
+	} {
+		ts, err := StrToDates(s)
+		if err != nil {
+			t.Fatalf("StrToDates(%q) error = %v", s, err)
+		}
+		if !ts[0].Equal(want) || ts[0].Location().String() != "America/New_York" {
+			t.Errorf("StrToDates(%q) = %v, want %v", s, ts[0], want)
+		}
+	}
+
+	for _, s := range []string{"VALUE=DATE;TZID=UTC:20180223", "TZID=UTC;VALUE=DATE:20180223"} {
+		ts, err := StrToDates(s)
+		if err != nil {
+			t.Fatalf("StrToDates(%q) error = %v", s, err)
+		}
+		if want := time.Date(2018, 2, 23, 0, 0, 0, 0, time.UTC); !ts[0].Equal(want) {
+			t.Errorf("StrToDates(%q) = %v, want %v", s, ts[0], want)
+		}
+	}
+
+	set, err := StrToRRuleSet("RDATE;TZID=America/New_York;VALUE=DATE-TIME:20180223T090000\n" +
+		"EXDATE;VALUE=DATE-TIME;TZID=America/New_York:20180223T090000")
+	if err != nil {
+		t.Fatal("expected", nil, "got", err)
+	}
+	if !set.GetRDate()[0].Equal(want) || !set.GetExDate()[0].Equal(want) {
+		t.Errorf("get %v and %v, want %v", set.GetRDate(), set.GetExDate(), want)
+	}
+}
+
+func TestToRFC5545(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	r, _ := NewRRule(ROption{
+		Freq:      WEEKLY,
+		Dtstart:   time.Date(2018, 1, 1, 9, 0, 0, 0, ny),
+		Interval:  1,
+		Wkst:      SU,
+		Until:     time.Date(2018, 3, 1, 9, 0, 0, 0, ny),
+		Bysetpos:  []int{1},
+		Byweekday: []Weekday{MO, WE},
+		Byhour:    []int{9},
+	})
+	want := "FREQ=WEEKLY;UNTIL=20180301T140000Z;BYHOUR=9;BYDAY=MO,WE;BYSETPOS=1;WKST=SU"
+	if s, err := r.ToRFC5545(); err != nil || s != want {
+		t.Errorf("get %q, %v, want %q", s, err, want)
+	}
+
+	r, _ = NewRRule(ROption{Freq: YEARLY, Byeaster: []int{0}})
+	if _, err := r.ToRFC5545(); err == nil {
+		t.Error("get nil, want error")
+	}
+}
+
+func TestStrToRRuleSetStrict(t *testing.T) {
+	valid := "DTSTART;TZID=America/New_York:20180101T090000\n" +
+		"RRULE:FREQ=WEEKLY;COUNT=3\n" +
+		"RDATE;TZID=America/New_York:20180102T090000\n" +
+		"EXDATE:20180108T140000Z"
+	set, err := StrToRRuleSetStrict(valid)
+	if err != nil {
+		t.Fatalf("StrToRRuleSetStrict(%q) error = %v", valid, err)
+	}
+	if len(set.All()) != 3 {
+		t.Errorf("get %v, want 3 occurrences", set.All())
+	}
+
+	for _, s := range []string{
+		"",
+		"RRULE:FREQ=YEARLY;BYEASTER=0",
+		"RRULE:FREQ=DAILY\nEXRULE:FREQ=WEEKLY",
+		"RRULE:FREQ=DAILY;DTSTART=20180101T090000Z",
+		"RRULE:FREQ=DAILY;COUNT=3;UNTIL=20180201T090000Z",
+		"DTSTART:20180101T090000Z\nDTSTART:20180101T090000Z\nRRULE:FREQ=DAILY",
+		"DTSTART;TZID=Nowhere/Nothing:20180101T090000\nRRULE:FREQ=DAILY",
+		"RRULE:FREQ=DAILY\nX-FOO:BAR",
+	} {
+		if _, err := StrToRRuleSetStrict(s); err == nil {
+			t.Errorf("StrToRRuleSetStrict(%q) error = nil, want not nil", s)
+		}
+	}
+}
+
+func TestParseRRuleSet(t *testing.T) {
+	lines := []string{
+		"DTSTART:20180101T090000",
+		"RRULE:FREQ=DAILY",
+		"EXRULE:FREQ=WEEKLY;BYDAY=SA,SU",
+	}
+	loc := time.FixedZone("UTC+8", 8*3600)
+	set, err := ParseRRuleSet(lines, ParseOptions{Location: loc, AllowEXRULE: true, MaxOccurrences: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := set.All()
+	if len(value) != 6 || value[0] != time.Date(2018, 1, 1, 9, 0, 0, 0, loc) ||
+		value[5] != time.Date(2018, 1, 8, 9, 0, 0, 0, loc) {
+		t.Errorf("get %v", value)
+	}
+
+	if _, err := ParseRRuleSet(lines, ParseOptions{}); err == nil {
+		t.Error("get nil error for EXRULE, want error")
+	}
+	easter := []string{"RRULE:FREQ=YEARLY;BYEASTER=0"}
+	if _, err := ParseRRuleSet(easter, ParseOptions{}); err == nil {
+		t.Error("get nil error for BYEASTER, want error")
+	}
+	if _, err := ParseRRuleSet(easter, DefaultParseOptions()); err != nil {
+		t.Errorf("get %v for BYEASTER with default options, want nil", err)
+	}
+	options := DefaultParseOptions()
+	options.Strict = true
+	if _, err := ParseRRuleSet([]string{"RRULE:FREQ=DAILY", "X-FOO:BAR"}, options); err == nil {
+		t.Error("get nil error for unknown property in strict mode, want error")
+	}
+	if _, err := ParseRRuleSet(lines, options); err != nil {
+		t.Errorf("get %v for EXRULE allowed in strict mode, want nil", err)
+	}
+}
+
+func TestWeekdayNames(t *testing.T) {
+	names := []string{"LU", "MA", "ME", "JE", "VE", "SA", "DI"}
+	str := "FREQ=WEEKLY;DTSTART=20180101T090000Z;WKST=DI;COUNT=3;BYDAY=LU,VE"
+	r, err := StrToRRuleWithWeekdayNames(str, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Weekday{MO, FR}; r.OrigOptions.Byweekday[0] != want[0] || r.OrigOptions.Byweekday[1] != want[1] ||
+		r.OrigOptions.Wkst != SU {
+		t.Errorf("get %v and WKST %v, want %v and WKST %v", r.OrigOptions.Byweekday, r.OrigOptions.Wkst, want, SU)
+	}
+	// BYDAY is sorted by the RFC 5545 abbreviations.
+	if s, want := r.StringWithWeekdayNames(), strings.Replace(str, "LU,VE", "VE,LU", 1); s != want {
+		t.Errorf("get %q, want %q", s, want)
+	}
+	// Other serializations use the RFC 5545 abbreviations.
+	if s, want := r.String(), "FREQ=WEEKLY;DTSTART=20180101T090000Z;WKST=SU;COUNT=3;BYDAY=FR,MO"; s != want {
+		t.Errorf("get %q, want %q", s, want)
+	}
+	set := &Set{}
+	set.RRule(r)
+	parsed, err := NewSetFromString(set.String())
+	if err != nil || !timesEqual(parsed.All(), r.All()) {
+		t.Errorf("NewSetFromString(%q) = %v, %v", set.String(), parsed, err)
+	}
+	if s, err := r.ToTextCalendar(); err != nil || !strings.Contains(s, "RRULE:FREQ=WEEKLY;COUNT=3;BYDAY=FR,MO;WKST=SU\r\n") {
+		t.Errorf("get %q, %v, want RFC 5545 weekdays", s, err)
+	}
+
+	option, err := StrToROptionWithWeekdayNames("FREQ=MONTHLY;BYDAY=-1Sonntag,+2Montag",
+		[]string{"Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag", "Sonntag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if option.Byweekday[0] != SU.Nth(-1) || option.Byweekday[1] != MO.Nth(2) {
+		t.Errorf("get %v, want [-1SU +2MO]", option.Byweekday)
+	}
+
+	for _, names := range [][]string{
+		{"LU", "MA"},
+		{"LU", "MA", "ME", "JE", "VE", "SA", "SA"},
+		{"LU", "MA", "ME", "JE", "VE", "SA", "D1"},
+	} {
+		if _, err := StrToROptionWithWeekdayNames("FREQ=WEEKLY", names); err == nil {
+			t.Errorf("get nil error for %v, want error", names)
+		}
+		if _, err := NewRRule(ROption{Freq: WEEKLY, WeekdayNames: names}); err == nil {
+			t.Errorf("get nil error for %v, want error", names)
+		}
+	}
+	if _, err := StrToRRuleWithWeekdayNames("FREQ=WEEKLY;BYDAY=MO", names); err == nil {
+		t.Error("get nil error, want error")
+	}
+}
+
+func TestNewFromString(t *testing.T) {
+	str := "FREQ=DAILY;DTSTART=20180101T090000Z;COUNT=3"
+	r, err := NewRRuleFromString(str)
+	if err != nil || r.String() != str {
+		t.Errorf("get %v, %v, want %s", r, err, str)
+	}
+
+	setStr := "DTSTART:20180101T090000Z\nRRULE:FREQ=DAILY;COUNT=3\nEXDATE:20180102T090000Z"
+	set, err := NewSetFromString(setStr)
+	if err != nil || set.String() != setStr {
+		t.Errorf("get %v, %v, want %s", set, err, setStr)
+	}
+	set, err = NewSetFromSlice(strings.Split(setStr, "\n"))
+	if err != nil || set.String() != setStr {
+		t.Errorf("get %v, %v, want %s", set, err, setStr)
+	}
+	if _, err := NewSetFromString(" "); err == nil {
+		t.Error("get nil error, want error")
+	}
+}
+
+func TestStrSliceToRRuleSetOrder(t *testing.T) {
+	lines := []string{
+		"DTSTART:20180101T090000Z",
+		"RRULE:FREQ=YEARLY;COUNT=2",
+		"RRULE:FREQ=DAILY;COUNT=3",
+		"RRULE:FREQ=MONTHLY;COUNT=4",
+		"EXRULE:FREQ=WEEKLY;COUNT=5",
+		"EXRULE:FREQ=HOURLY;COUNT=6",
+	}
+	set, err := StrSliceToRRuleSet(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range set.GetRRule() {
+		if want := lines[1+i][len("RRULE:"):]; r.OrigOptions.String() != want {
+			t.Errorf("get %v at %d, want %v", &r.OrigOptions, i, want)
+		}
+	}
+	for i, r := range set.GetExRule() {
+		if want := lines[4+i][len("EXRULE:"):]; r.OrigOptions.String() != want {
+			t.Errorf("get %v at %d, want %v", &r.OrigOptions, i, want)
+		}
+	}
+	if value := set.Recurrence(); strings.Join(value, "\n") != strings.Join(lines, "\n") {
+		t.Errorf("get %v, want %v", value, lines)
+	}
+}
+
+func TestRScale(t *testing.T) {
+	_, err := StrToRRule("RSCALE=HEBREW;FREQ=YEARLY;BYMONTH=5L")
+	if err == nil {
+		t.Error("get nil error, want error")
+	}
+	_, err = StrToRRule("RSCALE=CHINESE;FREQ=YEARLY")
+	if err != ErrRScaleUnsupported {
+		t.Errorf("get %v, want %v", err, ErrRScaleUnsupported)
+	}
+
+	r, err := StrToRRule("RSCALE=GREGORIAN;FREQ=YEARLY;DTSTART=20180101T090000Z;COUNT=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "FREQ=YEARLY;RSCALE=GREGORIAN;DTSTART=20180101T090000Z;COUNT=2"; r.String() != want {
+		t.Errorf("get %v, want %v", r, want)
+	}
+}
+
+func TestBysetposCanonicalOrder(t *testing.T) {
+	for _, str := range []string{
+		"FREQ=MONTHLY;BYSETPOS=-1,1;BYDAY=MO,TU",
+		"FREQ=MONTHLY;BYSETPOS=1,-1;BYDAY=MO,TU",
+	} {
+		r, err := StrToRRule(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.OrigOptions.Dtstart = time.Time{}
+		if want := "FREQ=MONTHLY;BYSETPOS=1,-1;BYDAY=MO,TU"; r.String() != want {
+			t.Errorf("get %v, want %v", r, want)
+		}
+	}
+
+	option := ROption{Freq: MONTHLY, Bysetpos: []int{-1, 3, -2, 1}}
+	if want := "FREQ=MONTHLY;BYSETPOS=1,3,-1,-2"; option.String() != want {
+		t.Errorf("get %v, want %v", &option, want)
+	}
+	if option.Bysetpos[0] != -1 {
+		t.Errorf("get %v, want the option unchanged", option.Bysetpos)
+	}
+}
+
+func TestStrToRRuleSetDtStartAfterRules(t *testing.T) {
+	set, err := StrToRRuleSet("RRULE:FREQ=DAILY;COUNT=3\n" +
+		"EXDATE:20180102T090000\n" +
+		"DTSTART;TZID=America/New_York:20180101T090000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ny, _ := time.LoadLocation("America/New_York")
+	want := []time.Time{
+		time.Date(2018, 1, 1, 9, 0, 0, 0, ny),
+		time.Date(2018, 1, 3, 9, 0, 0, 0, ny),
+	}
+	if value := set.All(); len(value) != 2 || !value[0].Equal(want[0]) || !value[1].Equal(want[1]) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+
+	_, err = StrToRRuleSet("DTSTART:20180101T090000Z\nRRULE:FREQ=DAILY\nDTSTART:20180101T090000Z")
+	if err == nil {
+		t.Error("get nil error, want error")
+	}
+}
+
+func TestStrToRRuleSetMultipleExDates(t *testing.T) {
+	set, err := StrToRRuleSet("DTSTART:20180101T090000Z\n" +
+		"RRULE:FREQ=DAILY;COUNT=6\n" +
+		"EXDATE:20180102T090000Z,20180104T090000Z\n" +
+		"EXDATE;TZID=America/New_York:20180105T040000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{
+		time.Date(2018, 1, 2, 9, 0, 0, 0, time.UTC),
+		time.Date(2018, 1, 4, 9, 0, 0, 0, time.UTC),
+		time.Date(2018, 1, 5, 9, 0, 0, 0, time.UTC),
+	}
+	exDates := set.GetExDate()
+	if len(exDates) != len(want) {
+		t.Fatalf("get %v, want %v", exDates, want)
+	}
+	for i := range want {
+		if !exDates[i].Equal(want[i]) {
+			t.Errorf("get %v, want %v", exDates, want)
+		}
+	}
+	want = []time.Time{
+		time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC),
+		time.Date(2018, 1, 3, 9, 0, 0, 0, time.UTC),
+		time.Date(2018, 1, 6, 9, 0, 0, 0, time.UTC),
+	}
+	if value := set.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+}
+
+func TestHasNamedZone(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	dt := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{dt, false}, {dt.Local(), false}, {time.Time{}.In(ny), false},
+		{dt.In(time.FixedZone("X", 3600)), false}, {dt.In(ny), true}, {dt.In(ny), true},
+	} {
+		if value := hasNamedZone(c.t); value != c.want {
+			t.Errorf("hasNamedZone(%v) = %v, want %v", c.t, value, c.want)
+		}
+	}
+	if _, ok := namedZones.Load("America/New_York"); !ok {
+		t.Error("America/New_York is not cached")
+	}
+}
+
+func BenchmarkSetStringRDates(b *testing.B) {
+	ny, _ := time.LoadLocation("America/New_York")
+	set := &Set{}
+	for i := 0; i < 1000; i++ {
+		set.RDate(time.Date(2018, 1, 1, 9, 0, 0, 0, ny).AddDate(0, 0, i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = set.String()
+	}
+}
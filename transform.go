@@ -0,0 +1,251 @@
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TransformedSet is a Set whose occurrences are transformed as they are
+// generated, as returned by Set.Map and Set.Shift.
+type TransformedSet struct {
+	set    *Set
+	offset time.Duration
+	// fn is applied after offset, nil for a constant offset.
+	fn func(time.Time) time.Time
+}
+
+// Map returns a set which applies fn to every occurrence of set.
+// Occurrences are not materialized, and are generated in the order of set,
+// so fn should preserve the order of times for Between, Before and After to
+// be meaningful. Use Shift for a constant offset, which can be serialized.
+func (set *Set) Map(fn func(time.Time) time.Time) *TransformedSet {
+	return &TransformedSet{set: set.clone(), fn: fn}
+}
+
+// Shift returns a set whose occurrences are the ones of set moved by offset.
+func (set *Set) Shift(offset time.Duration) *TransformedSet {
+	return &TransformedSet{set: set.clone(), offset: offset}
+}
+
+// Iterator returns an iterator for the transformed occurrences.
+func (ts *TransformedSet) Iterator() Next {
+	next := ts.set.Iterator()
+	return func() (time.Time, bool) {
+		v, ok := next()
+		if !ok {
+			return v, ok
+		}
+		v = v.Add(ts.offset)
+		if ts.fn != nil {
+			v = ts.fn(v)
+		}
+		return v, true
+	}
+}
+
+// All returns all transformed occurrences.
+func (ts *TransformedSet) All() []time.Time {
+	return all(ts.Iterator())
+}
+
+// Between returns all the transformed occurrences between after and before.
+// The inc keyword defines what happens if after and/or before are themselves
+// occurrences. With inc == True, they will be included in the list, if they
+// are found in the recurrence set.
+func (ts *TransformedSet) Between(after, before time.Time, inc bool) []time.Time {
+	return between(ts.Iterator(), after, before, inc)
+}
+
+// Before returns the last transformed occurrence before the given datetime.
+// With inc == True, if dt itself is an occurrence, it will be returned.
+func (ts *TransformedSet) Before(dt time.Time, inc bool) time.Time {
+	return before(ts.Iterator(), dt, inc)
+}
+
+// After returns the first transformed occurrence after the given datetime.
+// With inc == True, if dt itself is an occurrence, it will be returned.
+func (ts *TransformedSet) After(dt time.Time, inc bool) time.Time {
+	return after(ts.Iterator(), dt, inc)
+}
+
+// Recurrence returns the recurrence of the set followed by an
+// X-RRULE-OFFSET property holding the offset, if the set was built by
+// Shift. An error is returned if the offset is not a whole number of
+// seconds, which durations of RFC 5545 can not express. A set built by Map
+// is written as the RDATEs of all its occurrences, and an error is returned
+// if it is unbounded. The result is parsed by NewTransformedSetFromString.
+func (ts *TransformedSet) Recurrence() ([]string, error) {
+	if ts.fn == nil {
+		if ts.offset%time.Second != 0 {
+			return nil, fmt.Errorf("can not serialize the offset %v, which is not a whole number of seconds", ts.offset)
+		}
+		res := ts.set.Recurrence()
+		if ts.offset != 0 {
+			res = append(res, fmt.Sprintf("X-RRULE-OFFSET:%s", durationToStr(ts.offset)))
+		}
+		return res, nil
+	}
+	if !ts.set.bounded() {
+		return nil, errors.New("can not serialize an unbounded mapped set")
+	}
+	return dateLines("RDATE", ts.All()), nil
+}
+
+// MarshalText implements encoding.TextMarshaler, encoding the recurrence of
+// the set joined by newlines.
+func (ts *TransformedSet) MarshalText() ([]byte, error) {
+	res, err := ts.Recurrence()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(res, "\n")), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, decoding a string
+// accepted by NewTransformedSetFromString.
+func (ts *TransformedSet) UnmarshalText(text []byte) error {
+	parsed, err := NewTransformedSetFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*ts = *parsed
+	return nil
+}
+
+// NewTransformedSetFromString converts the recurrence written by
+// TransformedSet.Recurrence to a TransformedSet. It accepts the lines of
+// NewSetFromString and an optional X-RRULE-OFFSET property, which is not
+// accepted by NewSetFromString.
+func NewTransformedSetFromString(s string) (*TransformedSet, error) {
+	var lines []string
+	var offset time.Duration
+	found := false
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		value := strings.TrimSpace(line)
+		if !strings.HasPrefix(strings.ToUpper(value), "X-RRULE-OFFSET:") {
+			lines = append(lines, line)
+			continue
+		}
+		if found {
+			return nil, errors.New("X-RRULE-OFFSET must not occur more than once")
+		}
+		d, err := strToDuration(value[len("X-RRULE-OFFSET:"):])
+		if err != nil {
+			return nil, err
+		}
+		offset, found = d, true
+	}
+	set, err := NewSetFromString(strings.Join(lines, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	return &TransformedSet{set: set, offset: offset}, nil
+}
+
+// bounded reports whether the set generates a finite number of occurrences.
+func (set *Set) bounded() bool {
+	if set.truncated {
+		return true
+	}
+	for _, r := range set.rrule {
+		if r.OrigOptions.Count == 0 && r.OrigOptions.Until.IsZero() {
+			return false
+		}
+	}
+	for _, m := range set.merged {
+		if !m.bounded() {
+			return false
+		}
+	}
+	return true
+}
+
+// durationToStr formats d, a whole number of seconds, as a duration value
+// of RFC 5545 section 3.3.6.
+func durationToStr(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	if d == 0 {
+		return "PT0S"
+	}
+	var b strings.Builder
+	b.WriteString(sign + "P")
+	if days := d / (24 * time.Hour); days != 0 {
+		fmt.Fprintf(&b, "%dD", days)
+		d -= days * 24 * time.Hour
+	}
+	if d != 0 {
+		b.WriteString("T")
+	}
+	for _, unit := range []struct {
+		d    time.Duration
+		name string
+	}{{time.Hour, "H"}, {time.Minute, "M"}, {time.Second, "S"}} {
+		if n := d / unit.d; n != 0 {
+			fmt.Fprintf(&b, "%d%s", n, unit.name)
+			d -= n * unit.d
+		}
+	}
+	return b.String()
+}
+
+// strToDuration parses a duration value of RFC 5545 section 3.3.6.
+func strToDuration(s string) (time.Duration, error) {
+	bad := fmt.Errorf("bad duration: %q", s)
+	str := s
+	sign := time.Duration(1)
+	if strings.HasPrefix(str, "-") || strings.HasPrefix(str, "+") {
+		if str[0] == '-' {
+			sign = -1
+		}
+		str = str[1:]
+	}
+	if !strings.HasPrefix(str, "P") || len(str) == 1 {
+		return 0, bad
+	}
+	date, clock := str[1:], ""
+	if i := strings.Index(date, "T"); i >= 0 {
+		date, clock = date[:i], date[i+1:]
+		if clock == "" {
+			return 0, bad
+		}
+	}
+	var d time.Duration
+	for _, part := range []struct {
+		s     string
+		units string
+	}{{date, "WD"}, {clock, "HMS"}} {
+		str, units := part.s, part.units
+		for str != "" {
+			i := 0
+			for i < len(str) && str[i] >= '0' && str[i] <= '9' {
+				i++
+			}
+			if i == 0 || i == len(str) {
+				return 0, bad
+			}
+			n, err := strconv.Atoi(str[:i])
+			if err != nil {
+				return 0, bad
+			}
+			j := strings.IndexByte(units, str[i])
+			if j < 0 {
+				return 0, bad
+			}
+			d += time.Duration(n) * durationUnits[units[j]]
+			units, str = units[j+1:], str[i+1:]
+		}
+	}
+	return sign * d, nil
+}
+
+// durationUnits are the lengths of the units of duration values.
+var durationUnits = map[byte]time.Duration{
+	'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour,
+	'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+}
@@ -0,0 +1,120 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetShift(t *testing.T) {
+	set := Set{}
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 3, Dtstart: time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	shifted := set.Shift(-30 * time.Minute)
+	value := shifted.All()
+	want := []time.Time{
+		time.Date(2018, 1, 1, 9, 30, 0, 0, time.UTC),
+		time.Date(2018, 1, 2, 9, 30, 0, 0, time.UTC),
+		time.Date(2018, 1, 3, 9, 30, 0, 0, time.UTC),
+	}
+	if !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if value := shifted.After(want[0], false); value != want[1] {
+		t.Errorf("get %v, want %v", value, want[1])
+	}
+	text, err := shifted.MarshalText()
+	if want := "RRULE:FREQ=DAILY;DTSTART=20180101T100000Z;COUNT=3\nX-RRULE-OFFSET:-PT30M"; err != nil || string(text) != want {
+		t.Errorf("get %q, %v, want %q", text, err, want)
+	}
+	parsed, err := NewTransformedSetFromString(string(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := parsed.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	if _, err := NewSetFromString(string(text)); err == nil {
+		t.Error("get nil error for X-RRULE-OFFSET in a set, want error")
+	}
+	if len(set.All()) != 3 || set.All()[0] != r.DateStart {
+		t.Error("Shift modified the set")
+	}
+	// Offsets which are not whole seconds can not be serialized.
+	for _, offset := range []time.Duration{time.Minute + 500*time.Millisecond, -time.Nanosecond} {
+		if _, err := set.Shift(offset).Recurrence(); err == nil {
+			t.Errorf("get nil error for offset %v, want error", offset)
+		}
+		if _, err := set.Shift(offset).MarshalText(); err == nil {
+			t.Errorf("get nil error for offset %v, want error", offset)
+		}
+	}
+}
+
+func TestSetMap(t *testing.T) {
+	set := Set{}
+	r, _ := NewRRule(ROption{Freq: DAILY, Count: 2, Dtstart: time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	mapped := set.Map(func(t time.Time) time.Time { return t.AddDate(0, 1, 0) })
+	want := []time.Time{
+		time.Date(2018, 2, 1, 10, 0, 0, 0, time.UTC),
+		time.Date(2018, 2, 2, 10, 0, 0, 0, time.UTC),
+	}
+	if value := mapped.All(); !timesEqual(value, want) {
+		t.Errorf("get %v, want %v", value, want)
+	}
+	text, err := mapped.MarshalText()
+	if want := "RDATE:20180201T100000Z,20180202T100000Z"; err != nil || string(text) != want {
+		t.Errorf("get %q, %v, want %q", text, err, want)
+	}
+	var parsed TransformedSet
+	if err := parsed.UnmarshalText(text); err != nil || !timesEqual(parsed.All(), want) {
+		t.Errorf("get %v, %v, want %v", parsed.All(), err, want)
+	}
+
+	r, _ = NewRRule(ROption{Freq: DAILY, Dtstart: time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)})
+	set.RRule(r)
+	if _, err := set.Map(func(t time.Time) time.Time { return t }).Recurrence(); err == nil {
+		t.Error("get nil error for unbounded set, want error")
+	}
+	if _, err := set.Map(func(t time.Time) time.Time { return t }).MarshalText(); err == nil {
+		t.Error("get nil error for unbounded set, want error")
+	}
+	if _, err := set.Truncate(2).Map(func(t time.Time) time.Time { return t }).Recurrence(); err != nil {
+		t.Errorf("get %v for truncated set, want nil", err)
+	}
+}
+
+func TestDurationToStr(t *testing.T) {
+	cases := map[time.Duration]string{
+		0:                             "PT0S",
+		time.Second:                   "PT1S",
+		-90 * time.Minute:             "-PT1H30M",
+		48 * time.Hour:                "P2D",
+		25*time.Hour + 30*time.Second: "P1DT1H30S",
+	}
+	for d, want := range cases {
+		if value := durationToStr(d); value != want {
+			t.Errorf("durationToStr(%v) = %q, want %q", d, value, want)
+		}
+	}
+}
+
+func TestStrToDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"PT0S":      0,
+		"-PT1H30M":  -90 * time.Minute,
+		"+P2D":      48 * time.Hour,
+		"P1DT1H30S": 25*time.Hour + 30*time.Second,
+		"P2W":       14 * 24 * time.Hour,
+	}
+	for s, want := range cases {
+		if value, err := strToDuration(s); err != nil || value != want {
+			t.Errorf("strToDuration(%q) = %v, %v, want %v", s, value, err, want)
+		}
+	}
+	for _, s := range []string{"", "P", "PT", "P1DT", "1D", "PT1D", "P1H", "PT1M1H", "P1D1D", "PTM"} {
+		if _, err := strToDuration(s); err == nil {
+			t.Errorf("strToDuration(%q) = nil error, want error", s)
+		}
+	}
+}
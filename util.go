@@ -1,22 +1,60 @@
 package rrule
 
 import (
+	"context"
 	"errors"
 	"math"
+	"sort"
 	"time"
 )
 
-// MAXYEAR
+// MAXYEAR is the last year for which occurrences are generated.
+// Iteration of a rule stops once it goes beyond this year.
 const (
 	MAXYEAR = 9999
 )
 
+// ErrMaxYearExceeded is returned when iteration was stopped by reaching MAXYEAR,
+// or the implicit UNTIL of a rule without COUNT or UNTIL.
+var ErrMaxYearExceeded = errors.New("iteration stopped at MAXYEAR")
+
+// ErrAlreadyExists is returned when adding a date which is already in a set.
+var ErrAlreadyExists = errors.New("date already exists")
+
+// ErrRScaleUnsupported is returned by NewRRule for rules with an RSCALE
+// other than GREGORIAN.
+var ErrRScaleUnsupported = errors.New("RSCALE (RFC 7529) is not supported, only the Gregorian calendar is")
+
+// ErrInsufficientOccurrences is returned by GenerateN when a rule has fewer
+// occurrences than requested.
+var ErrInsufficientOccurrences = errors.New("insufficient occurrences")
+
 // Next is a generator of time.Time.
 // It returns false of Ok if there is no value to generate.
 type Next func() (value time.Time, ok bool)
 
 type timeSlice []time.Time
 
+// timeRange is the open interval (after, before).
+type timeRange struct {
+	after, before time.Time
+}
+
+func (tr timeRange) contains(t time.Time) bool {
+	return t.After(tr.after) && t.Before(tr.before)
+}
+
+// String formats the range as its bounds in UTC separated by a slash.
+// Occurrences have a precision of a second, so after is rounded down and
+// before up to the second without changing the occurrences in the range.
+func (tr timeRange) String() string {
+	before := tr.before.Truncate(time.Second)
+	if before.Before(tr.before) {
+		before = before.Add(time.Second)
+	}
+	return timeToStr(tr.after.Truncate(time.Second)) + "/" + timeToStr(before)
+}
+
 func (s timeSlice) Len() int           { return len(s) }
 func (s timeSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s timeSlice) Less(i, j int) bool { return s[i].Before(s[j]) }
@@ -40,6 +78,20 @@ func daysIn(m time.Month, year int) int {
 	return time.Date(year, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
 }
 
+// daysBetween returns the number of calendar days from the date of a to the date of b.
+func daysBetween(a, b time.Time) int {
+	y, m, d := a.Date()
+	from := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	y, m, d = b.Date()
+	to := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return int((to.Unix() - from.Unix()) / 86400)
+}
+
+// inLocation returns the time with the same wall-clock time as t in loc.
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
 // mod in Python
 func pymod(a, b int) int {
 	r := a % b
@@ -143,10 +195,30 @@ func all(next Next) []time.Time {
 }
 
 func between(next Next, after, before time.Time, inc bool) []time.Time {
+	return all(betweenIterator(next, after, before, inc))
+}
+
+// betweenIterator returns an iterator over the values of next between
+// after and before. The inc keyword is as for RRule.Between.
+func betweenIterator(next Next, after, before time.Time, inc bool) Next {
+	return func() (time.Time, bool) {
+		for {
+			v, ok := next()
+			if !ok || inc && v.After(before) || !inc && !v.Before(before) {
+				return time.Time{}, false
+			}
+			if inc && !v.Before(after) || !inc && v.After(after) {
+				return v, true
+			}
+		}
+	}
+}
+
+func allAfter(next Next, after time.Time, inc bool) []time.Time {
 	result := []time.Time{}
 	for {
 		v, ok := next()
-		if !ok || inc && v.After(before) || !inc && !v.Before(before) {
+		if !ok {
 			return result
 		}
 		if inc && !v.Before(after) || !inc && v.After(after) {
@@ -155,6 +227,41 @@ func between(next Next, after, before time.Time, inc bool) []time.Time {
 	}
 }
 
+func allBefore(next Next, before time.Time, inc bool) []time.Time {
+	result := []time.Time{}
+	for {
+		v, ok := next()
+		if !ok || inc && v.After(before) || !inc && !v.Before(before) {
+			return result
+		}
+		result = append(result, v)
+	}
+}
+
+// contextIterator returns an iterator which stops, storing the error of ctx
+// in err, once ctx is done.
+func contextIterator(ctx context.Context, next Next, err *error) Next {
+	return func() (time.Time, bool) {
+		if *err = ctx.Err(); *err != nil {
+			return time.Time{}, false
+		}
+		return next()
+	}
+}
+
+func betweenCount(next Next, after, before time.Time, inc bool) int {
+	count := 0
+	for {
+		v, ok := next()
+		if !ok || inc && v.After(before) || !inc && !v.Before(before) {
+			return count
+		}
+		if inc && !v.Before(after) || !inc && v.After(after) {
+			count++
+		}
+	}
+}
+
 func before(next Next, dt time.Time, inc bool) time.Time {
 	result := time.Time{}
 	for {
@@ -177,3 +284,132 @@ func after(next Next, dt time.Time, inc bool) time.Time {
 		}
 	}
 }
+
+func gaps(times []time.Time) []time.Duration {
+	result := []time.Duration{}
+	for i := 1; i < len(times); i++ {
+		result = append(result, times[i].Sub(times[i-1]))
+	}
+	return result
+}
+
+func copyInts(s []int) []int {
+	if s == nil {
+		return nil
+	}
+	return append([]int{}, s...)
+}
+
+func copyTimes(s []time.Time) []time.Time {
+	if s == nil {
+		return nil
+	}
+	return append([]time.Time{}, s...)
+}
+
+func skipIterator(next Next, n int) Next {
+	return func() (time.Time, bool) {
+		for ; n > 0; n-- {
+			if _, ok := next(); !ok {
+				return time.Time{}, false
+			}
+		}
+		return next()
+	}
+}
+
+func truncateIterator(next Next, n int) Next {
+	return func() (time.Time, bool) {
+		if n <= 0 {
+			return time.Time{}, false
+		}
+		n--
+		return next()
+	}
+}
+
+// windows groups sorted times into windows [t0+k*step, t0+k*step+size)
+// for every window starting before end.
+func windows(times []time.Time, t0, end time.Time, size, step time.Duration) [][]time.Time {
+	result := [][]time.Time{}
+	if size <= 0 || step <= 0 {
+		return result
+	}
+	first := 0
+	for start := t0; start.Before(end); start = start.Add(step) {
+		for first < len(times) && times[first].Before(start) {
+			first++
+		}
+		stop := start.Add(size)
+		window := []time.Time{}
+		for i := first; i < len(times) && times[i].Before(stop); i++ {
+			window = append(window, times[i])
+		}
+		result = append(result, window)
+	}
+	return result
+}
+
+func excludeRangesIterator(next Next, ranges []timeRange) Next {
+	return func() (time.Time, bool) {
+	outer:
+		for {
+			v, ok := next()
+			if !ok {
+				return v, ok
+			}
+			for _, tr := range ranges {
+				if tr.contains(v) {
+					continue outer
+				}
+			}
+			return v, true
+		}
+	}
+}
+
+// addTime inserts t into the sorted list unless an equal time is already there.
+func addTime(list *[]time.Time, t time.Time) error {
+	sort.Sort(timeSlice(*list))
+	s := *list
+	i := sort.Search(len(s), func(i int) bool { return !s[i].Before(t) })
+	if i < len(s) && s[i].Equal(t) {
+		return ErrAlreadyExists
+	}
+	s = append(s, time.Time{})
+	copy(s[i+1:], s[i:])
+	s[i] = t
+	*list = s
+	return nil
+}
+
+// uniqueTimes returns a sorted copy of list without duplicated times.
+func uniqueTimes(list []time.Time) []time.Time {
+	var result []time.Time
+	for _, t := range list {
+		addTime(&result, t)
+	}
+	return result
+}
+
+// removeTime removes the time equal to t from the sorted list, if any.
+func removeTime(list *[]time.Time, t time.Time) bool {
+	sort.Sort(timeSlice(*list))
+	s := *list
+	i := sort.Search(len(s), func(i int) bool { return !s[i].Before(t) })
+	if i == len(s) || !s[i].Equal(t) {
+		return false
+	}
+	*list = append(s[:i], s[i+1:]...)
+	return true
+}
+
+func reduce(next Next, acc interface{}, fn func(interface{}, time.Time) interface{}) interface{} {
+	for {
+		v, ok := next()
+		if !ok {
+			return acc
+		}
+		acc = fn(acc, v)
+	}
+}
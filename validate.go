@@ -0,0 +1,143 @@
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ValidationWarning describes a non-fatal issue found in a rule string.
+type ValidationWarning struct {
+	Code    string
+	Field   string
+	Message string
+}
+
+func (w ValidationWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.Field, w.Message)
+}
+
+// Codes of the warnings returned by ParseAndValidate.
+const (
+	WarnExRuleDeprecated      = "EXRULE_DEPRECATED"
+	WarnCountAndUntil         = "COUNT_AND_UNTIL"
+	WarnRedundantInterval     = "REDUNDANT_INTERVAL"
+	WarnRedundantWkst         = "REDUNDANT_WKST"
+	WarnBysetposWithoutFilter = "BYSETPOS_WITHOUT_FILTER"
+)
+
+// ParseAndValidate is like NewRRuleFromString but also reports non-fatal issues of
+// the rule string as warnings. The string may be prefixed with "RRULE:" or
+// "EXRULE:". Warnings are only returned along with a parsed rule.
+func ParseAndValidate(s string) (*RRule, []ValidationWarning, error) {
+	var warnings []ValidationWarning
+	warn := func(code, field, message string) {
+		warnings = append(warnings, ValidationWarning{code, field, message})
+	}
+
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToUpper(s), "EXRULE:") {
+		warn(WarnExRuleDeprecated, "EXRULE", "EXRULE is deprecated by RFC 5545")
+		s = s[len("EXRULE:"):]
+	} else if strings.HasPrefix(strings.ToUpper(s), "RRULE:") {
+		s = s[len("RRULE:"):]
+	}
+	r, err := NewRRuleFromString(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A DTSTART line is followed by the rule, as accepted by
+	// NewRRuleFromString.
+	if lines := strings.Split(s, "\n"); len(lines) == 2 {
+		s = strings.TrimSpace(lines[1])
+		s = s[strings.Index(s, ":")+1:]
+	}
+	keys := ruleValues(s)
+	if _, ok := keys["COUNT"]; ok {
+		if _, ok := keys["UNTIL"]; ok {
+			warn(WarnCountAndUntil, "COUNT", "COUNT and UNTIL must not occur in the same rule")
+		}
+	}
+	if v, ok := keys["INTERVAL"]; ok && r.OrigOptions.Interval == 1 {
+		warn(WarnRedundantInterval, "INTERVAL", fmt.Sprintf("INTERVAL=%s is the default", v))
+	}
+	if v, ok := keys["WKST"]; ok && r.OrigOptions.Wkst == MO {
+		warn(WarnRedundantWkst, "WKST", fmt.Sprintf("WKST=%s is the default", v))
+	}
+	if _, ok := keys["BYSETPOS"]; ok {
+		_, byday := keys["BYDAY"]
+		_, bymonthday := keys["BYMONTHDAY"]
+		if !byday && !bymonthday {
+			warn(WarnBysetposWithoutFilter, "BYSETPOS", "BYSETPOS is used without BYDAY or BYMONTHDAY")
+		}
+	}
+	return r, warnings, nil
+}
+
+// Validate returns an error if the combination of the rule's options is
+// guaranteed to produce no occurrence, such as BYMONTH=2;BYMONTHDAY=30.
+// Rules accepted by NewRRule are always usable, so this is a diagnostic
+// rather than a requirement.
+func (r *RRule) Validate() error {
+	option := r.OrigOptions
+	if !option.Until.IsZero() && r.UntilTime.Before(r.DateStart) {
+		return errors.New("UNTIL is before DTSTART")
+	}
+
+	months := option.Bymonth
+	if len(months) == 0 {
+		months = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	}
+	if len(option.Bymonthday) != 0 {
+		maxDays := 0
+		for _, m := range months {
+			// 2000 is a leap year, so February has 29 days.
+			if days := time.Date(2000, time.Month(m+1), 0, 0, 0, 0, 0, time.UTC).Day(); days > maxDays {
+				maxDays = days
+			}
+		}
+		possible := false
+		for _, d := range option.Bymonthday {
+			if d <= maxDays && d >= -maxDays {
+				possible = true
+			}
+		}
+		if !possible {
+			return fmt.Errorf("BYMONTHDAY=%v never occurs in BYMONTH=%v", option.Bymonthday, months)
+		}
+	}
+
+	if len(option.Byyearday) != 0 && len(option.Bymonth) != 0 {
+		possible := false
+		for _, d := range option.Byyearday {
+			for _, year := range []int{1999, 2000} {
+				yearlen := time.Date(year, 12, 31, 0, 0, 0, 0, time.UTC).YearDay()
+				yday := d
+				if yday < 0 {
+					yday += yearlen + 1
+				}
+				if yday < 1 || yday > yearlen {
+					continue
+				}
+				month := int(time.Date(year, 1, yday, 0, 0, 0, 0, time.UTC).Month())
+				if contains(option.Bymonth, month) {
+					possible = true
+				}
+			}
+		}
+		if !possible {
+			return fmt.Errorf("BYYEARDAY=%v never occurs in BYMONTH=%v", option.Byyearday, option.Bymonth)
+		}
+	}
+
+	if option.Freq == MONTHLY || option.Freq == YEARLY && len(option.Bymonth) != 0 {
+		for _, wday := range option.Byweekday {
+			if wday.n > 5 || wday.n < -5 {
+				return fmt.Errorf("BYDAY=%v never occurs in a month", wday)
+			}
+		}
+	}
+	return nil
+}
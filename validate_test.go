@@ -0,0 +1,92 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAndValidate(t *testing.T) {
+	cases := []struct {
+		str   string
+		codes []string
+	}{
+		{"FREQ=WEEKLY;BYDAY=MO", nil},
+		{"RRULE:FREQ=WEEKLY;INTERVAL=1;WKST=MO", []string{WarnRedundantInterval, WarnRedundantWkst}},
+		{"EXRULE:FREQ=DAILY;COUNT=3;UNTIL=20180101T000000Z", []string{WarnExRuleDeprecated, WarnCountAndUntil}},
+		{"FREQ=MONTHLY;BYSETPOS=1", []string{WarnBysetposWithoutFilter}},
+		{"FREQ=MONTHLY;BYSETPOS=1;BYDAY=MO", nil},
+	}
+	for _, c := range cases {
+		r, warnings, err := ParseAndValidate(c.str)
+		if err != nil || r == nil {
+			t.Fatalf("ParseAndValidate(%q) error = %v", c.str, err)
+		}
+		if len(warnings) != len(c.codes) {
+			t.Errorf("ParseAndValidate(%q) warnings = %v, want %v", c.str, warnings, c.codes)
+			continue
+		}
+		for i, w := range warnings {
+			if w.Code != c.codes[i] {
+				t.Errorf("ParseAndValidate(%q) warnings = %v, want %v", c.str, warnings, c.codes)
+			}
+		}
+	}
+
+	if _, _, err := ParseAndValidate("FREQ=WEEKLY;BYMONTH=13"); err == nil {
+		t.Error("get nil, want error")
+	}
+
+	// The output of String and of the String method of the options is
+	// accepted whatever the zone of DTSTART.
+	ny, _ := time.LoadLocation("America/New_York")
+	for _, loc := range []*time.Location{time.UTC, ny} {
+		r, _ := NewRRule(ROption{Freq: WEEKLY, Count: 3, Interval: 2, Dtstart: time.Date(2018, 1, 1, 9, 0, 0, 0, loc)})
+		for _, s := range []string{r.String(), r.OrigOptions.String()} {
+			value, warnings, err := ParseAndValidate(s)
+			if err != nil || value.OrigOptions.String() != r.OrigOptions.String() || len(warnings) != 0 {
+				t.Errorf("ParseAndValidate(%q) = %v, %v, %v", s, value, warnings, err)
+			}
+		}
+	}
+	value, warnings, err := ParseAndValidate("DTSTART;TZID=America/New_York:20180101T090000\nRRULE:FREQ=DAILY;INTERVAL=1")
+	if err != nil || !value.DateStart.Equal(time.Date(2018, 1, 1, 9, 0, 0, 0, ny)) ||
+		len(warnings) != 1 || warnings[0].Code != WarnRedundantInterval {
+		t.Errorf("get %v, %v, %v", value, warnings, err)
+	}
+}
+
+func TestRRuleValidate(t *testing.T) {
+	dtstart := time.Date(1997, 9, 2, 9, 0, 0, 0, time.UTC)
+	valid := []ROption{
+		{Freq: YEARLY},
+		{Freq: YEARLY, Bymonth: []int{2}, Bymonthday: []int{29}},
+		{Freq: YEARLY, Bymonth: []int{2, 4}, Bymonthday: []int{30, 31}},
+		{Freq: YEARLY, Bymonth: []int{3}, Byyearday: []int{60}},
+		{Freq: YEARLY, Bymonth: []int{12}, Byyearday: []int{-1}},
+		{Freq: YEARLY, Byweekday: []Weekday{MO.Nth(20)}},
+		{Freq: MONTHLY, Byweekday: []Weekday{MO.Nth(-5)}},
+	}
+	for _, option := range valid {
+		option.Dtstart = dtstart
+		r, _ := NewRRule(option)
+		if err := r.Validate(); err != nil {
+			t.Errorf("%v: get %v, want nil", r, err)
+		}
+	}
+
+	invalid := []ROption{
+		{Freq: DAILY, Until: dtstart.AddDate(0, 0, -1)},
+		{Freq: YEARLY, Bymonth: []int{2}, Bymonthday: []int{30}},
+		{Freq: YEARLY, Bymonth: []int{4, 6}, Bymonthday: []int{31, -31}},
+		{Freq: YEARLY, Bymonth: []int{2}, Byyearday: []int{1, -1}},
+		{Freq: YEARLY, Bymonth: []int{1}, Byweekday: []Weekday{MO.Nth(6)}},
+		{Freq: MONTHLY, Byweekday: []Weekday{FR.Nth(-6)}},
+	}
+	for _, option := range invalid {
+		option.Dtstart = dtstart
+		r, _ := NewRRule(option)
+		if err := r.Validate(); err == nil {
+			t.Errorf("%v: get nil, want error", r)
+		}
+	}
+}
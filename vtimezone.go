@@ -0,0 +1,329 @@
+package rrule
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// vtimezoneObservance holds a STANDARD or DAYLIGHT sub-component of a
+// VTIMEZONE block.
+type vtimezoneObservance struct {
+	dtstart    time.Time
+	offsetFrom int
+	offsetTo   int
+	name       string
+	rule       *ROption
+}
+
+// extractVTimezones removes all VTIMEZONE blocks from ss and returns the
+// locations they define, keyed by TZID, along with the remaining lines.
+func extractVTimezones(ss []string) (map[string]*time.Location, []string, error) {
+	var zones map[string]*time.Location
+	var rest []string
+	for i := 0; i < len(ss); i++ {
+		if !strings.EqualFold(strings.TrimSpace(ss[i]), "BEGIN:VTIMEZONE") {
+			rest = append(rest, ss[i])
+			continue
+		}
+		end := i + 1
+		for end < len(ss) && !strings.EqualFold(strings.TrimSpace(ss[end]), "END:VTIMEZONE") {
+			end++
+		}
+		if end == len(ss) {
+			return nil, nil, fmt.Errorf("VTIMEZONE is not terminated")
+		}
+		tzid, loc, err := parseVTimezone(ss[i+1 : end])
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad VTIMEZONE: %v", err)
+		}
+		if zones == nil {
+			zones = map[string]*time.Location{}
+		}
+		zones[tzid] = loc
+		i = end
+	}
+	return zones, rest, nil
+}
+
+// parseVTimezone builds a location from the lines between BEGIN:VTIMEZONE
+// and END:VTIMEZONE. Only the most recent STANDARD and DAYLIGHT
+// observances are taken into account.
+func parseVTimezone(ss []string) (string, *time.Location, error) {
+	var tzid string
+	var standard, daylight, current *vtimezoneObservance
+	for _, line := range ss {
+		line = strings.TrimSpace(line)
+		name, err := processRRuleName(line)
+		if err != nil {
+			return "", nil, err
+		}
+		value := line[strings.Index(line, ":")+1:]
+		switch name {
+		case "TZID":
+			tzid = strings.Trim(value, `"`)
+		case "BEGIN":
+			if current != nil {
+				return "", nil, fmt.Errorf("nested %s", value)
+			}
+			current = &vtimezoneObservance{}
+		case "END":
+			if current == nil {
+				return "", nil, fmt.Errorf("unexpected END:%s", value)
+			}
+			switch strings.ToUpper(value) {
+			case "STANDARD":
+				if standard == nil || current.dtstart.After(standard.dtstart) {
+					standard = current
+				}
+			case "DAYLIGHT":
+				if daylight == nil || current.dtstart.After(daylight.dtstart) {
+					daylight = current
+				}
+			default:
+				return "", nil, fmt.Errorf("unsupported component: %s", value)
+			}
+			current = nil
+		case "DTSTART", "TZOFFSETFROM", "TZOFFSETTO", "TZNAME", "RRULE":
+			if current == nil {
+				return "", nil, fmt.Errorf("%s outside of STANDARD or DAYLIGHT", name)
+			}
+			err = current.set(name, value)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if tzid == "" {
+		return "", nil, fmt.Errorf("missing TZID")
+	}
+	if standard == nil {
+		standard, daylight = daylight, nil
+	}
+	if standard == nil {
+		return "", nil, fmt.Errorf("missing STANDARD")
+	}
+	if daylight == nil {
+		return tzid, time.FixedZone(tzid, standard.offsetTo), nil
+	}
+	loc, err := daylightLocation(tzid, standard, daylight)
+	return tzid, loc, err
+}
+
+func (o *vtimezoneObservance) set(name, value string) (err error) {
+	switch name {
+	case "DTSTART":
+		o.dtstart, err = strToTimeInLoc(value, time.UTC)
+	case "TZOFFSETFROM":
+		o.offsetFrom, err = strToUTCOffset(value)
+	case "TZOFFSETTO":
+		o.offsetTo, err = strToUTCOffset(value)
+	case "TZNAME":
+		o.name = value
+	case "RRULE":
+		o.rule, err = StrToROption(value)
+	}
+	return err
+}
+
+// strToUTCOffset parses a UTC offset such as "-0500" or "+053000" into
+// seconds east of UTC.
+func strToUTCOffset(str string) (int, error) {
+	if len(str) != 5 && len(str) != 7 || str[0] != '+' && str[0] != '-' {
+		return 0, fmt.Errorf("bad UTC offset: %s", str)
+	}
+	offset := 0
+	for i, unit := range []int{3600, 60, 1} {
+		if 1+2*i >= len(str) {
+			break
+		}
+		v, err := strconv.Atoi(str[1+2*i : 3+2*i])
+		if err != nil {
+			return 0, fmt.Errorf("bad UTC offset: %s", str)
+		}
+		offset += v * unit
+	}
+	if str[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
+}
+
+// daylightLocation builds a location switching between standard and
+// daylight every year, as described by a POSIX TZ string.
+func daylightLocation(tzid string, standard, daylight *vtimezoneObservance) (*time.Location, error) {
+	start, err := daylight.posixRule()
+	if err != nil {
+		return nil, err
+	}
+	end, err := standard.posixRule()
+	if err != nil {
+		return nil, err
+	}
+	stdName := posixZoneName(standard.name, standard.offsetTo)
+	tz := fmt.Sprintf("%s%s%s%s,%s,%s", stdName, posixOffset(standard.offsetTo),
+		posixZoneName(daylight.name, daylight.offsetTo), posixOffset(daylight.offsetTo), start, end)
+	return time.LoadLocationFromTZData(tzid, tzData(strings.Trim(stdName, "<>"), standard.offsetTo, tz))
+}
+
+// posixRule returns the yearly transition of the observance in the
+// "Mm.w.d/time" form of POSIX TZ strings. The transition is taken from
+// RRULE, or from DTSTART if the observance has no RRULE.
+func (o *vtimezoneObservance) posixRule() (string, error) {
+	month := int(o.dtstart.Month())
+	week := (o.dtstart.Day()-1)/7 + 1
+	weekday := int(o.dtstart.Weekday())
+	if o.rule != nil {
+		if o.rule.Freq != YEARLY || len(o.rule.Bymonth) != 1 || len(o.rule.Byweekday) != 1 {
+			return "", fmt.Errorf("unsupported RRULE: %s", o.rule)
+		}
+		month = o.rule.Bymonth[0]
+		week = o.rule.Byweekday[0].N()
+		weekday = (o.rule.Byweekday[0].Day() + 1) % 7
+	}
+	if week == -1 {
+		week = 5
+	}
+	if week < 1 || week > 5 {
+		return "", fmt.Errorf("unsupported transition week: %d", week)
+	}
+	hour, minute, second := o.dtstart.Clock()
+	return fmt.Sprintf("M%d.%d.%d/%d:%02d:%02d", month, week, weekday, hour, minute, second), nil
+}
+
+// posixZoneName returns name if it is a valid POSIX TZ zone abbreviation,
+// or a quoted abbreviation derived from offset otherwise.
+func posixZoneName(name string, offset int) string {
+	valid := len(name) >= 3
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z') {
+			valid = false
+		}
+	}
+	if valid {
+		return name
+	}
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("<%c%02d%02d>", sign, offset/3600, offset%3600/60)
+}
+
+// posixOffset formats offset, in seconds east of UTC, as a POSIX TZ offset,
+// which counts west of UTC.
+func posixOffset(offset int) string {
+	sign := ""
+	if offset > 0 {
+		sign = "-"
+	} else {
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%d:%02d:%02d", sign, offset/3600, offset%3600/60, offset%60)
+}
+
+// tzData returns a TZif version 2 file without transitions whose footer
+// describes the zone.
+func tzData(name string, offset int, tz string) []byte {
+	var b bytes.Buffer
+	block := func() {
+		b.WriteString("TZif2")
+		b.Write(make([]byte, 15))
+		// isutcnt, isstdcnt, leapcnt, timecnt, typecnt, charcnt
+		for _, n := range []uint32{0, 0, 0, 0, 1, uint32(len(name) + 1)} {
+			binary.Write(&b, binary.BigEndian, n)
+		}
+		binary.Write(&b, binary.BigEndian, int32(offset))
+		b.Write([]byte{0, 0})
+		b.WriteString(name)
+		b.WriteByte(0)
+	}
+	block()
+	block()
+	b.WriteString("\n" + tz + "\n")
+	return b.Bytes()
+}
+
+// vtimezoneLines returns a VTIMEZONE component describing loc with the
+// offsets and the yearly transitions it has in year. An error is returned
+// if loc has neither a single offset nor two transitions in year, which
+// can not be described by a STANDARD and a DAYLIGHT observance.
+func vtimezoneLines(loc *time.Location, year int) ([]string, error) {
+	type transition struct {
+		at       time.Time
+		from, to int
+		name     string
+	}
+	var transitions []transition
+	start := time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+	end := start.AddDate(1, 0, 0)
+	name, offset := start.Zone()
+	for t := start; t.Before(end); t = t.Add(24 * time.Hour) {
+		next := t.Add(24 * time.Hour)
+		_, nextOffset := next.Zone()
+		if nextOffset == offset {
+			continue
+		}
+		// Transitions are at whole seconds, so the first instant with the
+		// new offset is found to the second.
+		lo, hi := t, next
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if _, o := mid.Zone(); o == offset {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		at := hi.Truncate(time.Second)
+		toName, to := at.Zone()
+		transitions = append(transitions, transition{at, offset, to, toName})
+		offset = nextOffset
+	}
+
+	lines := []string{"BEGIN:VTIMEZONE", "TZID:" + loc.String()}
+	switch len(transitions) {
+	case 0:
+		lines = append(lines, "BEGIN:STANDARD", "DTSTART:19700101T000000",
+			"TZOFFSETFROM:"+utcOffsetToStr(offset), "TZOFFSETTO:"+utcOffsetToStr(offset),
+			"TZNAME:"+name, "END:STANDARD")
+	case 2:
+		for i, tr := range transitions {
+			kind := "STANDARD"
+			if tr.to > transitions[1-i].to {
+				kind = "DAYLIGHT"
+			}
+			// DTSTART of an observance is the local time before it.
+			local := tr.at.UTC().Add(time.Duration(tr.from) * time.Second)
+			week := (local.Day()-1)/7 + 1
+			if local.Day()+7 > daysIn(local.Month(), local.Year()) {
+				week = -1
+			}
+			wday := weekdays[toPyWeekday(local.Weekday())].Nth(week)
+			lines = append(lines, "BEGIN:"+kind, "DTSTART:"+local.Format(LocalDateTimeFormat),
+				"TZOFFSETFROM:"+utcOffsetToStr(tr.from), "TZOFFSETTO:"+utcOffsetToStr(tr.to),
+				fmt.Sprintf("RRULE:FREQ=YEARLY;BYMONTH=%d;BYDAY=%s", local.Month(), wday),
+				"TZNAME:"+tr.name, "END:"+kind)
+		}
+	default:
+		return nil, fmt.Errorf("can not describe the %d transitions of %s in %d", len(transitions), loc, year)
+	}
+	return append(lines, "END:VTIMEZONE"), nil
+}
+
+// utcOffsetToStr formats offset, in seconds east of UTC, as a UTC offset
+// such as "-0500", with seconds only if there are any.
+func utcOffsetToStr(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	s := fmt.Sprintf("%c%02d%02d", sign, offset/3600, offset%3600/60)
+	if offset%60 != 0 {
+		s += fmt.Sprintf("%02d", offset%60)
+	}
+	return s
+}
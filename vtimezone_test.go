@@ -0,0 +1,115 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+const fictionalVTimezone = `BEGIN:VTIMEZONE
+TZID:Fictional/Island
+BEGIN:STANDARD
+DTSTART:19701025T030000
+TZOFFSETFROM:+0230
+TZOFFSETTO:+0130
+TZNAME:FIT
+RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:19700329T020000
+TZOFFSETFROM:+0130
+TZOFFSETTO:+0230
+TZNAME:FIST
+RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU
+END:DAYLIGHT
+END:VTIMEZONE`
+
+func TestStrToRRuleSetVTimezone(t *testing.T) {
+	set, err := StrToRRuleSet(fictionalVTimezone + `
+DTSTART;TZID=Fictional/Island:20180101T090000
+RRULE:FREQ=MONTHLY;COUNT=12
+RDATE;TZID=Fictional/Island:20180715T120000`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dtstart := set.GetDTStart()
+	if dtstart.Location().String() != "Fictional/Island" {
+		t.Errorf("get location %v, want Fictional/Island", dtstart.Location())
+	}
+	if want := time.Date(2018, 1, 1, 7, 30, 0, 0, time.UTC); !dtstart.Equal(want) {
+		t.Errorf("get %v, want %v", dtstart, want)
+	}
+	all := set.All()
+	// 2018-03-25 is the last Sunday of March, 2018-10-28 the last one of October.
+	cases := map[int]time.Time{
+		2:  time.Date(2018, 3, 1, 7, 30, 0, 0, time.UTC),
+		3:  time.Date(2018, 4, 1, 6, 30, 0, 0, time.UTC),
+		7:  time.Date(2018, 7, 15, 9, 30, 0, 0, time.UTC),
+		10: time.Date(2018, 10, 1, 6, 30, 0, 0, time.UTC),
+		11: time.Date(2018, 11, 1, 7, 30, 0, 0, time.UTC),
+	}
+	for i, want := range cases {
+		if !all[i].Equal(want) {
+			t.Errorf("get %v at %d, want %v", all[i], i, want)
+		}
+	}
+	transition := time.Date(2018, 3, 25, 0, 29, 59, 0, time.UTC)
+	if _, offset := transition.In(dtstart.Location()).Zone(); offset != 90*60 {
+		t.Errorf("get offset %d before transition, want %d", offset, 90*60)
+	}
+	if _, offset := transition.Add(time.Second).In(dtstart.Location()).Zone(); offset != 150*60 {
+		t.Errorf("get offset %d after transition, want %d", offset, 150*60)
+	}
+}
+
+func TestStrToRRuleSetVTimezoneFixed(t *testing.T) {
+	set, err := StrToRRuleSet(`BEGIN:VTIMEZONE
+TZID:Fictional/Fixed
+BEGIN:STANDARD
+DTSTART:19700101T000000
+TZOFFSETFROM:-0345
+TZOFFSETTO:-0345
+END:STANDARD
+END:VTIMEZONE
+DTSTART;TZID=Fictional/Fixed:20180101T090000
+RRULE:FREQ=DAILY;COUNT=2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2018, 1, 1, 12, 45, 0, 0, time.UTC); !set.All()[0].Equal(want) {
+		t.Errorf("get %v, want %v", set.All()[0], want)
+	}
+
+	for _, s := range []string{
+		"BEGIN:VTIMEZONE\nTZID:X\nDTSTART;TZID=X:20180101T090000",
+		"BEGIN:VTIMEZONE\nBEGIN:STANDARD\nTZOFFSETTO:+0100\nEND:STANDARD\nEND:VTIMEZONE",
+		"BEGIN:VTIMEZONE\nTZID:X\nBEGIN:STANDARD\nTZOFFSETTO:+1\nEND:STANDARD\nEND:VTIMEZONE",
+	} {
+		if _, err := StrToRRuleSet(s); err == nil {
+			t.Errorf("StrToRRuleSet(%q) error = nil, want not nil", s)
+		}
+	}
+}
+
+func TestVTimezoneLines(t *testing.T) {
+	for _, name := range []string{"America/New_York", "Europe/London", "Australia/Sydney", "Asia/Tokyo", "Asia/Kolkata"} {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines, err := vtimezoneLines(loc, 2018)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		zones, rest, err := extractVTimezones(lines)
+		if err != nil || len(rest) != 0 || zones[name] == nil {
+			t.Fatalf("%s: get %v, %v, %v for %q", name, zones, rest, err, lines)
+		}
+		for at := time.Date(2018, 1, 1, 0, 30, 0, 0, time.UTC); at.Year() < 2020; at = at.Add(time.Hour) {
+			_, want := at.In(loc).Zone()
+			if _, offset := at.In(zones[name]).Zone(); offset != want {
+				t.Errorf("%s: get offset %d at %v, want %d", name, offset, at, want)
+				break
+			}
+		}
+	}
+}